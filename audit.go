@@ -0,0 +1,272 @@
+package grsync
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "*****"
+
+// AuditResult is a summary of a sync run
+type AuditResult struct {
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit code,omitempty"`
+	Stats    Stats  `json:"stats"`
+	// Guarantees are crash safety measures in effect for the run
+	Guarantees Guarantees `json:"guarantees"`
+}
+
+// AuditRecord is an entry of AuditLog; Hash covers the record with PreviousHash, so records form a chain
+type AuditRecord struct {
+	Sequence     uint64      `json:"sequence"`
+	Time         time.Time   `json:"time"`
+	Initiator    string      `json:"initiator"`
+	Argv         []string    `json:"argv"`
+	Result       AuditResult `json:"result"`
+	Deleted      []string    `json:"deleted,omitempty"`
+	PreviousHash string      `json:"previous hash"`
+	Hash         string      `json:"hash"`
+}
+
+// AuditChainError is returned by VerifyAuditLog when the log has gaps or edited records
+type AuditChainError struct {
+	// Line is a number of the first broken line starting from 1
+	Line   int
+	Reason string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("rsync: audit log is broken at line %d: %s", e.Line, e.Reason)
+}
+
+// AuditLog appends records of sync runs to a JSON Lines file
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	last AuditRecord
+}
+
+// OpenAuditLog opens log at path for appending, the existing chain is verified to continue it
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := verifyAuditFile(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &AuditLog{file: file, last: last}, nil
+}
+
+// verifyAuditFile drops a partial last line left by a crash during Append and verifies the rest
+func verifyAuditFile(file *os.File) (AuditRecord, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return AuditRecord{}, err
+	}
+
+	end := info.Size()
+	buffer := make([]byte, 4096)
+	for end > 0 {
+		n := int64(len(buffer))
+		if n > end {
+			n = end
+		}
+		if _, err = file.ReadAt(buffer[:n], end-n); err != nil {
+			return AuditRecord{}, err
+		}
+		if i := bytes.LastIndexByte(buffer[:n], '\n'); i >= 0 {
+			end += int64(i) + 1 - n
+			break
+		}
+		end -= n
+	}
+
+	if end < info.Size() {
+		if err = file.Truncate(end); err != nil {
+			return AuditRecord{}, err
+		}
+		if err = file.Sync(); err != nil {
+			return AuditRecord{}, err
+		}
+	}
+	return VerifyAuditLog(io.NewSectionReader(file, 0, end))
+}
+
+// Last returns the last record, its hash can be kept elsewhere to detect truncation of the log
+func (l *AuditLog) Last() AuditRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// Append fills sequence, time and hashes of the record and writes it durably
+func (l *AuditLog) Append(record AuditRecord) (AuditRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record.Sequence = l.last.Sequence + 1
+	record.PreviousHash = l.last.Hash
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.Time = record.Time.UTC()
+	record.Hash = ""
+	hash, err := auditHash(record)
+	if err != nil {
+		return AuditRecord{}, err
+	}
+	record.Hash = hash
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return AuditRecord{}, err
+	}
+	if _, err = l.file.Write(append(data, '\n')); err != nil {
+		return AuditRecord{}, err
+	}
+	if err = l.file.Sync(); err != nil {
+		return AuditRecord{}, err
+	}
+
+	l.last = record
+	return record, nil
+}
+
+// Record appends record of the finished task run by initiator
+func (l *AuditLog) Record(initiator string, task *Task, runErr error) (AuditRecord, error) {
+	result := AuditResult{Success: runErr == nil, Stats: task.Stats(), Guarantees: task.Guarantees()}
+	if runErr != nil {
+		result.Error = runErr.Error()
+		var rsyncErr *RsyncError
+		if errors.As(runErr, &rsyncErr) {
+			result.ExitCode = rsyncErr.Code
+		}
+	}
+
+	return l.Append(AuditRecord{
+		Initiator: initiator,
+		Argv:      redactArgv(task.rsync.cmd.Args, task.options),
+		Result:    result,
+		Deleted:   deletedFiles(task.log.Stdout),
+	})
+}
+
+// Close closes the log file
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Audit returns middleware which appends a record of every run to the log;
+// it enables ItemizeChanges, deleted files are taken from the itemized output
+func Audit(log *AuditLog, initiator string) Middleware {
+	return func(next Runner) Runner {
+		return RunnerFunc(func(task *Task) error {
+			if !task.options.ItemizeChanges {
+				options := task.options
+				options.ItemizeChanges = true
+				task.setOptions(options)
+			}
+
+			err := next.Run(task)
+			if _, auditErr := log.Record(initiator, task, err); auditErr != nil && err == nil {
+				return auditErr
+			}
+			return err
+		})
+	}
+}
+
+// VerifyAuditLog checks sequence numbers and hashes of all records and returns the last one
+func VerifyAuditLog(r io.Reader) (AuditRecord, error) {
+	var last AuditRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return last, &AuditChainError{Line: line, Reason: err.Error()}
+		}
+
+		if record.Sequence != last.Sequence+1 {
+			return last, &AuditChainError{Line: line, Reason: fmt.Sprintf("sequence %d follows %d", record.Sequence, last.Sequence)}
+		}
+		if record.PreviousHash != last.Hash {
+			return last, &AuditChainError{Line: line, Reason: "previous hash doesn't match"}
+		}
+
+		hash := record.Hash
+		record.Hash = ""
+		expected, err := auditHash(record)
+		if err != nil {
+			return last, err
+		}
+		if hash != expected {
+			return last, &AuditChainError{Line: line, Reason: "record hash doesn't match"}
+		}
+
+		record.Hash = hash
+		last = record
+	}
+
+	return last, scanner.Err()
+}
+
+func auditHash(record AuditRecord) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// redactArgv hides passwords in the command line
+func redactArgv(argv []string, options RsyncOptions) []string {
+	redactedArgv := make([]string, len(argv))
+	for i, argument := range argv {
+		if options.SSHPassword != "" {
+			argument = strings.ReplaceAll(argument, options.SSHPassword, redacted)
+		}
+		redactedArgv[i] = argument
+	}
+	return redactedArgv
+}
+
+// deletedFiles returns files deleted by rsync from its verbose or itemized output
+func deletedFiles(stdout string) []string {
+	const deletingPrefix = "deleting "
+
+	var deleted []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if event, ok := parseItemized(line, ""); ok {
+			if event.Change.Deleted() {
+				deleted = append(deleted, event.Name)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, deletingPrefix) {
+			deleted = append(deleted, strings.TrimPrefix(line, deletingPrefix))
+		}
+	}
+	return deleted
+}
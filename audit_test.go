@@ -0,0 +1,86 @@
+package grsync
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := OpenAuditLog(path)
+	require.NoError(t, err)
+
+	sshpass := filepath.Join(t.TempDir(), "sshpass")
+	require.NoError(t, os.WriteFile(sshpass, []byte("#!/bin/sh\nshift 2\nexec \"$@\"\n"), 0755))
+	binary := writeFakeRsync(t, itemizingRsync)
+	task := NewTask([]string{"src/"}, t.TempDir(), RsyncOptions{
+		RsyncBinaryPath:   binary,
+		SSHPassword:       "secret",
+		SSHPassBinaryPath: sshpass,
+		Delete:            true,
+	})
+	task.Use(Audit(log, "alice"))
+	require.NoError(t, task.Run())
+
+	failing := NewTask([]string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: writeFakeRsync(t, "exit 23")})
+	failing.Use(Audit(log, "bob"))
+	require.Error(t, failing.Run())
+	require.NoError(t, log.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	last, err := VerifyAuditLog(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), last.Sequence)
+	assert.Equal(t, "bob", last.Initiator)
+	assert.False(t, last.Result.Success)
+	assert.Equal(t, 23, last.Result.ExitCode)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	assert.NotContains(t, lines[0], "secret")
+	assert.Contains(t, lines[0], `"-p","*****"`)
+	assert.Contains(t, lines[0], `"deleted":["data/old.jpg"]`)
+	assert.Contains(t, lines[0], `"initiator":"alice"`)
+	assert.Contains(t, lines[1], `"--itemize-changes"`)
+
+	// reopened log drops the record partially written by a crash and continues the chain
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"sequence":3,"ti`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	log, err = OpenAuditLog(path)
+	require.NoError(t, err)
+	record, err := log.Append(AuditRecord{Initiator: "carol"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), record.Sequence)
+	assert.Equal(t, last.Hash, record.PreviousHash)
+	require.NoError(t, log.Close())
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	broken := map[string][]string{
+		"edited": {lines[0], strings.Replace(lines[1], `"bob"`, `"eve"`, 1), lines[2]},
+		"gap":    {lines[0], lines[2]},
+		"order":  {lines[1], lines[0], lines[2]},
+	}
+	for name, lines := range broken {
+		_, err = VerifyAuditLog(strings.NewReader(strings.Join(lines, "\n")))
+		var chainErr *AuditChainError
+		assert.True(t, errors.As(err, &chainErr), name)
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(broken["gap"], "\n")+"\n"), 0600))
+	_, err = OpenAuditLog(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,325 @@
+package grsync
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ChecksumKey is a version of file content; a cached checksum is valid while all fields are the same
+type ChecksumKey struct {
+	Inode uint64 `json:"inode"`
+	Size  int64  `json:"size"`
+	// ModTime is modification time in nanoseconds
+	ModTime int64 `json:"mtime ns"`
+	// ChangeTime is inode change time in nanoseconds; zero where it's unknown
+	ChangeTime int64 `json:"ctime ns"`
+}
+
+// ChecksumEntry is a cached sha256 checksum of a file
+type ChecksumEntry struct {
+	Key ChecksumKey `json:"key"`
+	Sum string      `json:"sum"`
+}
+
+// ChecksumStore keeps cached checksums by absolute file path
+type ChecksumStore interface {
+	Load(path string) (ChecksumEntry, bool, error)
+	Store(path string, entry ChecksumEntry) error
+}
+
+// CacheStats counts lookups of ChecksumCache
+type CacheStats struct {
+	// Hits is a number of checksums taken from the cache
+	Hits int `json:"hits"`
+	// Misses is a number of files hashed because their entry was missing or stale
+	Misses int `json:"misses"`
+}
+
+func (s CacheStats) sub(other CacheStats) CacheStats {
+	return CacheStats{Hits: s.Hits - other.Hits, Misses: s.Misses - other.Misses}
+}
+
+// ChecksumCache computes sha256 checksums of local files and keeps them in the store,
+// so unchanged files are not read again
+type ChecksumCache struct {
+	store ChecksumStore
+
+	mu    sync.Mutex
+	stats CacheStats
+}
+
+// NewChecksumCache returns cache backed by the store
+func NewChecksumCache(store ChecksumStore) *ChecksumCache {
+	return &ChecksumCache{store: store}
+}
+
+// Stats returns hits and misses since the cache was created
+func (c *ChecksumCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Sum returns checksum of the file, it's taken from the store if the file didn't change
+func (c *ChecksumCache) Sum(path string) (string, error) {
+	path, key, err := checksumKeyOf(path)
+	if err != nil {
+		return "", err
+	}
+
+	entry, ok, err := c.store.Load(path)
+	if err != nil {
+		return "", err
+	}
+	if ok && entry.Key.matches(key) {
+		c.count(true)
+		return entry.Sum, nil
+	}
+
+	c.count(false)
+	return c.refresh(path, key)
+}
+
+// check hashes the file even if it has a valid entry and reports whether the content differs
+// from the cached checksum while the key is the same, i.e. the data is corrupted
+func (c *ChecksumCache) check(path string) (bool, error) {
+	path, key, err := checksumKeyOf(path)
+	if err != nil {
+		return false, err
+	}
+
+	entry, ok, err := c.store.Load(path)
+	if err != nil {
+		return false, err
+	}
+	if !ok || !entry.Key.matches(key) {
+		c.count(false)
+		_, err = c.refresh(path, key)
+		return false, err
+	}
+
+	c.count(true)
+	sum, err := hashFile(path)
+	if err != nil {
+		return false, err
+	}
+	// the cached checksum is kept as the evidence of the original content
+	return sum != entry.Sum, nil
+}
+
+// refresh hashes the file and stores its checksum unless the file changed while it was read
+func (c *ChecksumCache) refresh(path string, key ChecksumKey) (string, error) {
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	_, after, err := checksumKeyOf(path)
+	if err != nil || after != key {
+		return sum, err
+	}
+	return sum, c.store.Store(path, ChecksumEntry{Key: key, Sum: sum})
+}
+
+func (c *ChecksumCache) count(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+}
+
+// matches compares keys, zero ChangeTime of the entry isn't compared
+func (k ChecksumKey) matches(current ChecksumKey) bool {
+	if k.ChangeTime == 0 {
+		current.ChangeTime = 0
+	}
+	return k == current
+}
+
+func checksumKeyOf(path string) (string, ChecksumKey, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", ChecksumKey{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", ChecksumKey{}, err
+	}
+
+	return path, ChecksumKey{
+		Inode:      inodeOf(info).ino,
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UnixNano(),
+		ChangeTime: changeTime(info),
+	}, nil
+}
+
+// SidecarChecksumStore keeps checksums in a JSON file, Save writes it
+type SidecarChecksumStore struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]ChecksumEntry
+}
+
+// NewSidecarChecksumStore loads the database at path; a missing file is an empty database
+func NewSidecarChecksumStore(path string) (*SidecarChecksumStore, error) {
+	store := &SidecarChecksumStore{path: path, entries: map[string]ChecksumEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store, json.Unmarshal(data, &store.entries)
+}
+
+func (s *SidecarChecksumStore) Load(path string) (ChecksumEntry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[path]
+	return entry, ok, nil
+}
+
+func (s *SidecarChecksumStore) Store(path string, entry ChecksumEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[path] = entry
+	return nil
+}
+
+// Save writes the database atomically, entries of removed files are dropped
+func (s *SidecarChecksumStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for path := range s.entries {
+		if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			delete(s.entries, path)
+		}
+	}
+
+	data, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+	temp := s.path + ".tmp"
+	if err = os.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, s.path)
+}
+
+// ScrubReport is a result of Scrub
+type ScrubReport struct {
+	// Files is a number of checked regular files
+	Files int `json:"files"`
+	// Corrupted lists files relative to root whose content changed while their key didn't
+	Corrupted []string   `json:"corrupted,omitempty"`
+	Cache     CacheStats `json:"cache"`
+}
+
+// Scrub reads every file under root and compares it with the cached checksum to find silent
+// corruption; files without a valid entry are hashed into the cache
+func Scrub(root string, cache *ChecksumCache) (ScrubReport, error) {
+	before := cache.Stats()
+	var report ScrubReport
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		report.Files++
+		corrupted, err := cache.check(path)
+		if err != nil || !corrupted {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		report.Corrupted = append(report.Corrupted, name)
+		return err
+	})
+
+	report.Cache = cache.Stats().sub(before)
+	return report, err
+}
+
+// VerifyLocal compares local trees natively by cached checksums, like Verify does with rsync
+// for `source/`, and returns NotInSyncError listing missing and different files
+func VerifyLocal(source, destination string, cache *ChecksumCache) (CacheStats, error) {
+	if isRemotePath(source) || isRemotePath(destination) {
+		return CacheStats{}, ErrLocalEndpoint
+	}
+
+	before := cache.Stats()
+	var changes []FileEvent
+	err := filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		name, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(destination, name)
+		flags, err := compareFiles(path, target, cache)
+		if err != nil || flags == "" {
+			return err
+		}
+		changes = append(changes, FileEvent{Name: name, Path: target, Change: ItemizedChange{Flags: flags}})
+		return nil
+	})
+
+	stats := cache.Stats().sub(before)
+	if err != nil {
+		return stats, err
+	}
+	if len(changes) > 0 {
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Name < changes[j].Name
+		})
+		return stats, &NotInSyncError{Changes: changes}
+	}
+	return stats, nil
+}
+
+// compareFiles returns itemized flags rsync would print for the target; empty if it's the same
+func compareFiles(source, target string, cache *ChecksumCache) (string, error) {
+	info, err := os.Stat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return ">f+++++++++", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return ">f+++++++++", nil
+	}
+
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+	if sourceInfo.Size() != info.Size() {
+		return ">fcs.......", nil
+	}
+
+	sourceSum, err := cache.Sum(source)
+	if err != nil {
+		return "", err
+	}
+	targetSum, err := cache.Sum(target)
+	if err != nil || sourceSum == targetSum {
+		return "", err
+	}
+	return ">fc........", nil
+}
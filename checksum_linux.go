@@ -0,0 +1,54 @@
+package grsync
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ChecksumXattr is the xattr where XattrChecksumStore keeps checksums
+const ChecksumXattr = "user.grsync.checksum"
+
+// XattrChecksumStore keeps checksum of a file in its own user xattr, so it follows renames and
+// hard links. Setting the xattr changes ctime of the file, so its entries are keyed without it.
+type XattrChecksumStore struct{}
+
+func (XattrChecksumStore) Load(path string) (ChecksumEntry, bool, error) {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, ChecksumXattr, buf)
+	if errors.Is(err, syscall.ENODATA) {
+		return ChecksumEntry{}, false, nil
+	}
+	if err != nil {
+		return ChecksumEntry{}, false, &os.PathError{Op: "getxattr", Path: path, Err: err}
+	}
+
+	var entry ChecksumEntry
+	if err = json.Unmarshal(buf[:n], &entry); err != nil {
+		// a foreign or damaged value is a miss, Store overwrites it
+		return ChecksumEntry{}, false, nil
+	}
+	return entry, true, nil
+}
+
+func (XattrChecksumStore) Store(path string, entry ChecksumEntry) error {
+	entry.Key.ChangeTime = 0
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err = syscall.Setxattr(path, ChecksumXattr, data, 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: path, Err: err}
+	}
+	return nil
+}
+
+// changeTime returns inode change time in nanoseconds
+func changeTime(info os.FileInfo) int64 {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+	return sys.Ctim.Nano()
+}
@@ -0,0 +1,40 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXattrChecksumStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+	cache := NewChecksumCache(XattrChecksumStore{})
+
+	_, err := cache.Sum(path)
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("user xattrs are not supported")
+	}
+	require.NoError(t, err)
+	_, err = cache.Sum(path)
+	require.NoError(t, err)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, cache.Stats())
+
+	// the xattr follows the rename
+	renamed := path + ".renamed"
+	require.NoError(t, os.Rename(path, renamed))
+	_, err = cache.Sum(renamed)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cache.Stats().Hits)
+
+	require.NoError(t, os.Chtimes(renamed, time.Now(), time.Now().Add(time.Hour)))
+	_, err = cache.Sum(renamed)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cache.Stats().Misses)
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package grsync
+
+import (
+	"errors"
+	"os"
+)
+
+// ChecksumXattr is the xattr where XattrChecksumStore keeps checksums
+const ChecksumXattr = "user.grsync.checksum"
+
+var errChecksumXattrUnsupported = errors.New("rsync: checksum xattrs are supported only on linux")
+
+// XattrChecksumStore keeps checksum of a file in its own user xattr, so it follows renames and
+// hard links. Setting the xattr changes ctime of the file, so its entries are keyed without it.
+type XattrChecksumStore struct{}
+
+func (XattrChecksumStore) Load(path string) (ChecksumEntry, bool, error) {
+	return ChecksumEntry{}, false, errChecksumXattrUnsupported
+}
+
+func (XattrChecksumStore) Store(path string, entry ChecksumEntry) error {
+	return errChecksumXattrUnsupported
+}
+
+// changeTime returns inode change time in nanoseconds; it isn't compared on this platform
+func changeTime(info os.FileInfo) int64 {
+	return 0
+}
@@ -0,0 +1,141 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	require.NoError(t, os.WriteFile(path, []byte("first"), 0644))
+	expected, err := hashFile(path)
+	require.NoError(t, err)
+
+	database := filepath.Join(dir, "checksums.json")
+	store, err := NewSidecarChecksumStore(database)
+	require.NoError(t, err)
+	cache := NewChecksumCache(store)
+
+	sum, err := cache.Sum(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, sum)
+	sum, err = cache.Sum(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, sum)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, cache.Stats())
+
+	t.Run("persisted", func(t *testing.T) {
+		require.NoError(t, store.Save())
+		reloaded, err := NewSidecarChecksumStore(database)
+		require.NoError(t, err)
+		cache := NewChecksumCache(reloaded)
+		_, err = cache.Sum(path)
+		require.NoError(t, err)
+		assert.Equal(t, CacheStats{Hits: 1}, cache.Stats())
+	})
+
+	t.Run("invalidated", func(t *testing.T) {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		if changeTime(info) == 0 {
+			t.Skip("change time is not available")
+		}
+		// same size and mtime, only ctime tells the change; it has a coarse clock
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, os.WriteFile(path, []byte("other"), 0644))
+		require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+
+		sum, err := cache.Sum(path)
+		require.NoError(t, err)
+		changed, err := hashFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, changed, sum)
+		assert.Equal(t, 2, cache.Stats().Misses)
+	})
+}
+
+func TestScrub(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, "a", "dir/b")
+	store, err := NewSidecarChecksumStore(filepath.Join(t.TempDir(), "checksums.json"))
+	require.NoError(t, err)
+	cache := NewChecksumCache(store)
+
+	report, err := Scrub(root, cache)
+	require.NoError(t, err)
+	assert.Equal(t, ScrubReport{Files: 2, Cache: CacheStats{Misses: 2}}, report)
+
+	// bit rot keeps metadata, the cached key stays valid
+	path, key, err := checksumKeyOf(filepath.Join(root, "dir/b"))
+	require.NoError(t, err)
+	entry, _, err := store.Load(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Store(path, ChecksumEntry{Key: key, Sum: "0000"}))
+	assert.Equal(t, key, entry.Key)
+
+	report, err = Scrub(root, cache)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir/b"}, report.Corrupted)
+	assert.Equal(t, CacheStats{Hits: 2}, report.Cache)
+}
+
+func TestVerifyLocal(t *testing.T) {
+	source, destination := t.TempDir(), t.TempDir()
+	writeTree(t, source, "same", "dir/changed", "missing")
+	writeTree(t, destination, "same", "dir/changed", "extra")
+	require.NoError(t, os.WriteFile(filepath.Join(destination, "dir/changed"), []byte("dir/chanGED"), 0644))
+	store, err := NewSidecarChecksumStore(filepath.Join(t.TempDir(), "checksums.json"))
+	require.NoError(t, err)
+	cache := NewChecksumCache(store)
+
+	stats, err := VerifyLocal(source, destination, cache)
+	var notInSync *NotInSyncError
+	require.True(t, errors.As(err, &notInSync), err)
+	require.Len(t, notInSync.Changes, 2)
+	assert.Equal(t, "dir/changed", notInSync.Changes[0].Name)
+	assert.Equal(t, ">fc........", notInSync.Changes[0].Change.Flags)
+	assert.Equal(t, "missing", notInSync.Changes[1].Name)
+	assert.True(t, notInSync.Changes[1].Change.Created())
+	assert.Equal(t, CacheStats{Misses: 4}, stats)
+
+	require.NoError(t, os.Remove(filepath.Join(source, "missing")))
+	require.NoError(t, os.WriteFile(filepath.Join(destination, "dir/changed"), []byte("dir/changed"), 0644))
+	stats, err = VerifyLocal(source, destination, cache)
+	assert.NoError(t, err)
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 1}, stats)
+
+	_, err = VerifyLocal(source, "host:/backup", cache)
+	assert.ErrorIs(t, err, ErrLocalEndpoint)
+}
+
+func TestDedupeCache(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "archive")
+	for _, name := range []string{"a/x", "b/x", "c/y"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755))
+		content := "same"
+		if name == "c/y" {
+			content = "diff"
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0644))
+	}
+	store, err := NewSidecarChecksumStore(filepath.Join(t.TempDir(), "checksums.json"))
+	require.NoError(t, err)
+	cache := NewChecksumCache(store)
+
+	report, err := Dedupe(root, DedupeOptions{IgnoreModTime: true, Cache: cache})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Linked)
+	assert.Equal(t, CacheStats{Misses: 3}, report.Cache)
+
+	report, err = Dedupe(root, DedupeOptions{IgnoreModTime: true, Cache: cache})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Linked)
+	assert.Equal(t, 1, report.Cache.Hits)
+}
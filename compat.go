@@ -0,0 +1,279 @@
+package grsync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// IssueKind is a kind of CompatibilityIssue
+type IssueKind string
+
+const (
+	// IssueCaseCollision names differ only in case, target silently merges them
+	IssueCaseCollision IssueKind = "case collision"
+	// IssueInvalidName name contains characters or words not allowed on target
+	IssueInvalidName IssueKind = "invalid name"
+	// IssueNameTooLong name is longer than target allows
+	IssueNameTooLong IssueKind = "name too long"
+	// IssuePathTooLong path is longer than target allows
+	IssuePathTooLong IssueKind = "path too long"
+	// IssueSymlink target doesn't support symlinks
+	IssueSymlink IssueKind = "symlink"
+	// IssueDevice target doesn't support device files
+	IssueDevice IssueKind = "device"
+	// IssueSpecial target doesn't support fifos and sockets
+	IssueSpecial IssueKind = "special file"
+	// IssueTimestamp modification time is out of target range
+	IssueTimestamp IssueKind = "timestamp"
+	// IssueOwnership target can't store owner and group
+	IssueOwnership IssueKind = "ownership"
+)
+
+const windowsInvalidChars = `"*:<>?\|`
+
+var windowsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// TargetProfile describes limitations of the destination filesystem
+type TargetProfile struct {
+	Name string
+	// CaseInsensitive names differ only in case refer to the same file
+	CaseInsensitive bool
+	// InvalidChars are characters not allowed in names
+	InvalidChars string
+	// NoControlChars forbids characters 0x01-0x1f in names
+	NoControlChars bool
+	// NoTrailingDotOrSpace forbids names ending with dot or space
+	NoTrailingDotOrSpace bool
+	// ReservedNames are names not allowed with any extension, compared case-insensitively
+	ReservedNames []string
+	// MaxNameLength in characters; 0 - unlimited
+	MaxNameLength int
+	// MaxPathLength in characters relative to the destination; 0 - unlimited
+	MaxPathLength int
+	// Symlinks are supported
+	Symlinks bool
+	// Devices are supported
+	Devices bool
+	// Specials fifos and sockets are supported
+	Specials bool
+	// Ownership owner and group are stored
+	Ownership bool
+	// MinTime and MaxTime is a range of modification times; zero - unlimited
+	MinTime time.Time
+	MaxTime time.Time
+}
+
+var (
+	// ProfileFAT is FAT32 and vfat target
+	ProfileFAT = TargetProfile{
+		Name:                 "fat",
+		CaseInsensitive:      true,
+		InvalidChars:         windowsInvalidChars,
+		NoControlChars:       true,
+		NoTrailingDotOrSpace: true,
+		ReservedNames:        windowsReservedNames,
+		MaxNameLength:        255,
+		MinTime:              time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC),
+		MaxTime:              time.Date(2107, time.December, 31, 23, 59, 58, 0, time.UTC),
+	}
+	// ProfileExFAT is exFAT target
+	ProfileExFAT = TargetProfile{
+		Name:                 "exfat",
+		CaseInsensitive:      true,
+		InvalidChars:         windowsInvalidChars,
+		NoControlChars:       true,
+		NoTrailingDotOrSpace: true,
+		MaxNameLength:        255,
+		MinTime:              time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC),
+		MaxTime:              time.Date(2107, time.December, 31, 23, 59, 59, 0, time.UTC),
+	}
+	// ProfileSMB is a Windows share mounted over SMB
+	ProfileSMB = TargetProfile{
+		Name:                 "smb",
+		CaseInsensitive:      true,
+		InvalidChars:         windowsInvalidChars,
+		NoControlChars:       true,
+		NoTrailingDotOrSpace: true,
+		ReservedNames:        windowsReservedNames,
+		MaxNameLength:        255,
+		MaxPathLength:        32767,
+		MinTime:              time.Date(1601, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	// ProfileCasefold is ext4 directory with casefold attribute
+	ProfileCasefold = TargetProfile{
+		Name:            "casefold",
+		CaseInsensitive: true,
+		MaxNameLength:   255,
+		Symlinks:        true,
+		Devices:         true,
+		Specials:        true,
+		Ownership:       true,
+	}
+)
+
+// CompatibilityIssue is a source file which can't be transferred to the target as is
+type CompatibilityIssue struct {
+	Path   string    `json:"path"`
+	Kind   IssueKind `json:"kind"`
+	Detail string    `json:"detail"`
+	// Options are RsyncOptions which make the transfer fail; empty means it fails with any options
+	Options []string `json:"options"`
+}
+
+// Fails reports whether the transfer with options fails because of the issue
+func (i CompatibilityIssue) Fails(options RsyncOptions) bool {
+	switch i.Kind {
+	case IssueSymlink:
+		return (options.Links || options.Archive) && !options.CopyLinks
+	case IssueDevice:
+		return options.Devices || options.Archive
+	case IssueSpecial:
+		return options.Specials || options.Archive
+	case IssueTimestamp:
+		return options.Times || options.Archive
+	case IssueOwnership:
+		return options.Owner || options.Group || options.Archive
+	}
+	return true
+}
+
+// CompatibilityReport contains all issues found by CheckCompatibility
+type CompatibilityReport struct {
+	Profile TargetProfile        `json:"profile"`
+	Issues  []CompatibilityIssue `json:"issues"`
+}
+
+// Failing returns issues which make the transfer with options fail
+func (r CompatibilityReport) Failing(options RsyncOptions) []CompatibilityIssue {
+	var issues []CompatibilityIssue
+	for _, issue := range r.Issues {
+		if issue.Fails(options) {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// CheckCompatibility scans local source and finds files which can't be stored on the target filesystem
+func CheckCompatibility(source string, profile TargetProfile) (CompatibilityReport, error) {
+	report := CompatibilityReport{Profile: profile}
+
+	// names are checked as they appear on the target, so without trailing slash
+	// the source directory itself is a part of the path
+	root := filepath.Clean(source)
+	base := filepath.Dir(root)
+	if strings.HasSuffix(source, "/") {
+		base = root
+	}
+
+	if !profile.Ownership {
+		report.add(source, IssueOwnership, "target doesn't store owner and group", "Owner", "Group", "Archive")
+	}
+
+	seen := map[string]map[string]string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == base {
+			return nil
+		}
+
+		relative, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+
+		if profile.CaseInsensitive {
+			dir := filepath.Dir(relative)
+			if seen[dir] == nil {
+				seen[dir] = map[string]string{}
+			}
+			folded := strings.ToLower(name)
+			if other, ok := seen[dir][folded]; ok {
+				report.add(relative, IssueCaseCollision, fmt.Sprintf("collides with %q", other))
+			} else {
+				seen[dir][folded] = name
+			}
+		}
+
+		if reason := profile.invalidName(name); reason != "" {
+			report.add(relative, IssueInvalidName, reason)
+		}
+		if profile.MaxNameLength > 0 && utf8.RuneCountInString(name) > profile.MaxNameLength {
+			report.add(relative, IssueNameTooLong, fmt.Sprintf("name is longer than %d", profile.MaxNameLength))
+		}
+		if profile.MaxPathLength > 0 && utf8.RuneCountInString(relative) > profile.MaxPathLength {
+			report.add(relative, IssuePathTooLong, fmt.Sprintf("path is longer than %d", profile.MaxPathLength))
+		}
+
+		mode := info.Mode()
+		switch {
+		case mode&os.ModeSymlink != 0 && !profile.Symlinks:
+			report.add(relative, IssueSymlink, "target doesn't support symlinks", "Links", "Archive")
+		case mode&os.ModeDevice != 0 && !profile.Devices:
+			report.add(relative, IssueDevice, "target doesn't support device files", "Devices", "Archive")
+		case mode&(os.ModeNamedPipe|os.ModeSocket) != 0 && !profile.Specials:
+			report.add(relative, IssueSpecial, "target doesn't support fifos and sockets", "Specials", "Archive")
+		}
+
+		modTime := info.ModTime()
+		if (!profile.MinTime.IsZero() && modTime.Before(profile.MinTime)) ||
+			(!profile.MaxTime.IsZero() && modTime.After(profile.MaxTime)) {
+			report.add(relative, IssueTimestamp, fmt.Sprintf("modification time %s is out of range", modTime.UTC().Format(time.RFC3339)), "Times", "Archive")
+		}
+
+		return nil
+	})
+
+	return report, err
+}
+
+func (r *CompatibilityReport) add(path string, kind IssueKind, detail string, options ...string) {
+	r.Issues = append(r.Issues, CompatibilityIssue{
+		Path:    path,
+		Kind:    kind,
+		Detail:  detail,
+		Options: options,
+	})
+}
+
+// invalidName returns the reason why name isn't allowed on target or empty string
+func (p TargetProfile) invalidName(name string) string {
+	if i := strings.IndexAny(name, p.InvalidChars); p.InvalidChars != "" && i >= 0 {
+		return fmt.Sprintf("contains invalid character %q", name[i])
+	}
+
+	if p.NoControlChars {
+		for _, char := range name {
+			if char < 0x20 {
+				return fmt.Sprintf("contains control character %q", char)
+			}
+		}
+	}
+
+	if p.NoTrailingDotOrSpace && (strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ")) {
+		return "ends with dot or space"
+	}
+
+	stem := name
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	for _, reserved := range p.ReservedNames {
+		if strings.EqualFold(stem, reserved) {
+			return fmt.Sprintf("%s is a reserved name", reserved)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,115 @@
+package grsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTree(t *testing.T, root string, files ...string) {
+	t.Helper()
+
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func issuesByKind(report CompatibilityReport) map[IssueKind][]string {
+	issues := map[IssueKind][]string{}
+	for _, issue := range report.Issues {
+		issues[issue.Kind] = append(issues[issue.Kind], issue.Path)
+	}
+	return issues
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root,
+		"src/Readme",
+		"src/README",
+		"src/dir/a:b",
+		"src/dir/CON.txt",
+		"src/dir/trailing.",
+		"src/"+strings.Repeat("n", 100),
+		"src/old",
+	)
+	assert.NoError(t, os.Symlink("Readme", filepath.Join(root, "src/link")))
+	old := time.Date(1970, time.January, 2, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(filepath.Join(root, "src/old"), old, old))
+
+	t.Run("fat", func(t *testing.T) {
+		report, err := CheckCompatibility(filepath.Join(root, "src")+"/", ProfileFAT)
+		assert.NoError(t, err)
+
+		issues := issuesByKind(report)
+		assert.Equal(t, []string{"Readme"}, issues[IssueCaseCollision])
+		assert.ElementsMatch(t, []string{"dir/a:b", "dir/CON.txt", "dir/trailing."}, issues[IssueInvalidName])
+		assert.Empty(t, issues[IssueNameTooLong])
+		assert.Equal(t, []string{"link"}, issues[IssueSymlink])
+		assert.Equal(t, []string{"old"}, issues[IssueTimestamp])
+		assert.Len(t, issues[IssueOwnership], 1)
+	})
+
+	t.Run("source without trailing slash", func(t *testing.T) {
+		report, err := CheckCompatibility(filepath.Join(root, "src"), ProfileFAT)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"src/Readme"}, issuesByKind(report)[IssueCaseCollision])
+	})
+
+	t.Run("casefold", func(t *testing.T) {
+		report, err := CheckCompatibility(filepath.Join(root, "src")+"/", ProfileCasefold)
+		assert.NoError(t, err)
+
+		issues := issuesByKind(report)
+		assert.Len(t, issues, 1)
+		assert.Equal(t, []string{"Readme"}, issues[IssueCaseCollision])
+	})
+
+	t.Run("length limits", func(t *testing.T) {
+		report, err := CheckCompatibility(filepath.Join(root, "src")+"/", TargetProfile{
+			MaxNameLength: 64,
+			MaxPathLength: 12,
+			Symlinks:      true,
+			Ownership:     true,
+		})
+		assert.NoError(t, err)
+
+		issues := issuesByKind(report)
+		assert.Equal(t, []string{strings.Repeat("n", 100)}, issues[IssueNameTooLong])
+		assert.ElementsMatch(t, []string{strings.Repeat("n", 100), "dir/trailing."}, issues[IssuePathTooLong])
+	})
+
+	t.Run("failing options", func(t *testing.T) {
+		report, err := CheckCompatibility(filepath.Join(root, "src")+"/", ProfileFAT)
+		assert.NoError(t, err)
+
+		kinds := func(issues []CompatibilityIssue) map[IssueKind]bool {
+			result := map[IssueKind]bool{}
+			for _, issue := range issues {
+				result[issue.Kind] = true
+			}
+			return result
+		}
+
+		archive := kinds(report.Failing(RsyncOptions{Archive: true}))
+		assert.True(t, archive[IssueSymlink])
+		assert.True(t, archive[IssueTimestamp])
+		assert.True(t, archive[IssueOwnership])
+
+		plain := kinds(report.Failing(RsyncOptions{Recursive: true, CopyLinks: true}))
+		assert.False(t, plain[IssueSymlink])
+		assert.False(t, plain[IssueTimestamp])
+		assert.True(t, plain[IssueInvalidName])
+		assert.True(t, plain[IssueCaseCollision])
+	})
+}
@@ -0,0 +1,335 @@
+package grsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdleTimeout  = 5 * time.Minute
+	defaultStartTimeout = 10 * time.Second
+	masterPollInterval  = 50 * time.Millisecond
+)
+
+// ErrConnectionManagerClosed is returned by closed ConnectionManager
+var ErrConnectionManagerClosed = errors.New("rsync: connection manager is closed")
+
+// ErrMasterNotStarted is returned when ssh control master doesn't come up
+var ErrMasterNotStarted = errors.New("rsync: ssh control master is not started")
+
+// ConnectionOptions for ConnectionManager
+type ConnectionOptions struct {
+	// SSH options used for masters and tasks
+	SSH SSHOptions
+	// IdleTimeout stops masters not used for this long; by default 5 minutes, negative disables
+	IdleTimeout time.Duration
+	// StartTimeout limits waiting for a new master; by default 10 seconds
+	StartTimeout time.Duration
+}
+
+// ConnectionManager owns OpenSSH control masters shared by tasks targeting the same host
+type ConnectionManager struct {
+	options ConnectionOptions
+	dir     string
+
+	mu          sync.Mutex
+	connections map[string]*connection
+	sockets     int
+	closed      bool
+	done        chan struct{}
+}
+
+// connection is a master of a host; ssh runs under its own lock, so hosts don't wait for each other
+type connection struct {
+	mu     sync.Mutex
+	master *master
+
+	// users and lastUsed are guarded by ConnectionManager.mu
+	users    int
+	lastUsed time.Time
+}
+
+type master struct {
+	endpoint Endpoint
+	socket   string
+	cmd      *exec.Cmd
+	exited   chan struct{}
+}
+
+// NewConnectionManager creates a private directory for control sockets
+func NewConnectionManager(options ConnectionOptions) (*ConnectionManager, error) {
+	if options.IdleTimeout == 0 {
+		options.IdleTimeout = defaultIdleTimeout
+	}
+	if options.StartTimeout <= 0 {
+		options.StartTimeout = defaultStartTimeout
+	}
+
+	// socket paths are limited to about 100 bytes, so the directory is kept short
+	dir, err := os.MkdirTemp("", "grsync-cm-")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &ConnectionManager{
+		options:     options,
+		dir:         dir,
+		connections: map[string]*connection{},
+		done:        make(chan struct{}),
+	}
+	if options.IdleTimeout > 0 {
+		go m.reapIdle()
+	}
+
+	return m, nil
+}
+
+// Options returns rsync options with Rsh using the control master of the endpoint,
+// the master is started or restarted when needed. Tasks should rather use Share,
+// otherwise the master may be stopped as idle while they run.
+func (m *ConnectionManager) Options(endpoint Endpoint, options RsyncOptions) (RsyncOptions, error) {
+	mst, err := m.master(endpoint)
+	if err != nil {
+		return options, err
+	}
+
+	options.Rsh = m.sshOptions(endpoint).With("ControlPath="+mst.socket, "ControlMaster=no").Rsh()
+	return options, nil
+}
+
+// Share returns middleware which sets Rsh of the task to the control master of the endpoint
+// and keeps the master from being stopped as idle until the run ends
+func (m *ConnectionManager) Share(endpoint Endpoint) Middleware {
+	return func(next Runner) Runner {
+		return RunnerFunc(func(task *Task) error {
+			_, release := m.acquire(endpoint)
+			defer release()
+
+			options, err := m.Options(endpoint, task.options)
+			if err != nil {
+				return err
+			}
+			task.setOptions(options)
+			return next.Run(task)
+		})
+	}
+}
+
+// Check reports whether the control master of the endpoint is alive
+func (m *ConnectionManager) Check(endpoint Endpoint) error {
+	m.mu.Lock()
+	conn, ok := m.connections[connectionKey(endpoint)]
+	m.mu.Unlock()
+	if !ok {
+		return ErrMasterNotStarted
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.master == nil {
+		return ErrMasterNotStarted
+	}
+	return m.control(conn.master, "check")
+}
+
+// Close stops all masters and removes the sockets directory
+func (m *ConnectionManager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+	connections := m.connections
+	m.connections = map[string]*connection{}
+	m.mu.Unlock()
+
+	for _, conn := range connections {
+		conn.mu.Lock()
+		if conn.master != nil {
+			m.stop(conn.master, "exit")
+			conn.master = nil
+		}
+		conn.mu.Unlock()
+	}
+
+	return os.RemoveAll(m.dir)
+}
+
+// acquire returns connection of the endpoint marked as used until release is called
+func (m *ConnectionManager) acquire(endpoint Endpoint) (*connection, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := connectionKey(endpoint)
+	conn, ok := m.connections[key]
+	if !ok {
+		conn = &connection{}
+		m.connections[key] = conn
+	}
+	conn.users++
+	conn.lastUsed = time.Now()
+
+	return conn, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		conn.users--
+		conn.lastUsed = time.Now()
+	}
+}
+
+func (m *ConnectionManager) master(endpoint Endpoint) (*master, error) {
+	if m.isClosed() {
+		return nil, ErrConnectionManagerClosed
+	}
+
+	conn, release := m.acquire(endpoint)
+	defer release()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.master != nil {
+		if m.control(conn.master, "check") == nil {
+			return conn.master, nil
+		}
+		m.stop(conn.master, "exit")
+		conn.master = nil
+	}
+
+	mst, err := m.start(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if m.isClosed() {
+		// Close didn't see the master being started
+		m.stop(mst, "exit")
+		return nil, ErrConnectionManagerClosed
+	}
+	conn.master = mst
+
+	return mst, nil
+}
+
+func (m *ConnectionManager) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+func (m *ConnectionManager) start(endpoint Endpoint) (*master, error) {
+	m.mu.Lock()
+	m.sockets++
+	// a master stopped as idle may still hold its socket, so every master gets a new one
+	name := strconv.Itoa(m.sockets)
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(connectionKey(endpoint)))
+	mst := &master{
+		endpoint: endpoint,
+		socket:   filepath.Join(m.dir, hex.EncodeToString(sum[:8])+"-"+name),
+		exited:   make(chan struct{}),
+	}
+
+	binaryPath, arguments := m.sshOptions(endpoint).command()
+	arguments = append(arguments, "-M", "-N", "-S", mst.socket, "-o", "ControlPersist=no", endpoint.Address())
+	mst.cmd = exec.Command(binaryPath, arguments...)
+	if err := mst.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		_ = mst.cmd.Wait()
+		close(mst.exited)
+	}()
+
+	deadline := time.After(m.options.StartTimeout)
+	for {
+		if m.control(mst, "check") == nil {
+			return mst, nil
+		}
+
+		select {
+		case <-mst.exited:
+			return nil, ErrMasterNotStarted
+		case <-deadline:
+			m.stop(mst, "exit")
+			return nil, ErrMasterNotStarted
+		case <-time.After(masterPollInterval):
+		}
+	}
+}
+
+// control sends control command, e.g. `check` or `exit`, to the master
+func (m *ConnectionManager) control(mst *master, command string) error {
+	binaryPath, arguments := m.sshOptions(mst.endpoint).command()
+	arguments = append(arguments, "-S", mst.socket, "-O", command, mst.endpoint.Address())
+	return exec.Command(binaryPath, arguments...).Run()
+}
+
+// stop asks the master to stop and kills it if it doesn't exit in time.
+// Command `stop` lets running sessions finish, `exit` terminates them.
+func (m *ConnectionManager) stop(mst *master, command string) {
+	_ = m.control(mst, command)
+
+	if command == "stop" {
+		return
+	}
+	select {
+	case <-mst.exited:
+	case <-time.After(m.options.StartTimeout):
+		_ = mst.cmd.Process.Kill()
+		<-mst.exited
+	}
+}
+
+func (m *ConnectionManager) reapIdle() {
+	ticker := time.NewTicker(m.options.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+
+		var idle []*connection
+		m.mu.Lock()
+		for key, conn := range m.connections {
+			if conn.users == 0 && time.Since(conn.lastUsed) >= m.options.IdleTimeout {
+				idle = append(idle, conn)
+				delete(m.connections, key)
+			}
+		}
+		m.mu.Unlock()
+
+		for _, conn := range idle {
+			conn.mu.Lock()
+			if conn.master != nil {
+				m.stop(conn.master, "stop")
+				conn.master = nil
+			}
+			conn.mu.Unlock()
+		}
+	}
+}
+
+func (m *ConnectionManager) sshOptions(endpoint Endpoint) SSHOptions {
+	options := m.options.SSH
+	if endpoint.Port > 0 {
+		options.Port = endpoint.Port
+	}
+	return options
+}
+
+func connectionKey(endpoint Endpoint) string {
+	return endpoint.Address() + ":" + strconv.Itoa(endpoint.Port)
+}
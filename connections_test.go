@@ -0,0 +1,182 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMasterSSH emulates ssh control master with a plain file as a socket
+const fakeMasterSSH = `echo "$@" >> "$(dirname "$0")/calls"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-S) socket=$2; shift 2 ;;
+	-O) command=$2; shift 2 ;;
+	-M) master=1; shift ;;
+	-p|-i|-o) shift 2 ;;
+	-*) shift ;;
+	*) host=$1; shift ;;
+	esac
+done
+case "$host" in *unreachable) exit 255 ;; esac
+if [ -n "$master" ]; then
+	case "$host" in *slow) sleep 1 ;; esac
+	touch "$socket"
+	while [ -e "$socket" ]; do sleep 0.02; done
+	exit 0
+fi
+case "$command" in
+check) [ -e "$socket" ] ;;
+exit|stop) rm -f "$socket" ;;
+esac
+`
+
+func currentMaster(manager *ConnectionManager, endpoint Endpoint) *master {
+	manager.mu.Lock()
+	conn := manager.connections[connectionKey(endpoint)]
+	manager.mu.Unlock()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.master
+}
+
+func TestConnectionManager(t *testing.T) {
+	endpoint := Endpoint{User: "backup", Host: "target", Port: 2222}
+
+	t.Run("shares master between tasks", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeMasterSSH)
+		manager, err := NewConnectionManager(ConnectionOptions{SSH: SSHOptions{BinaryPath: ssh}})
+		assert.NoError(t, err)
+
+		first, err := manager.Options(endpoint, RsyncOptions{Archive: true})
+		assert.NoError(t, err)
+		assert.True(t, first.Archive)
+		assert.Contains(t, first.Rsh, "-p 2222")
+		assert.Contains(t, first.Rsh, "-o ControlPath="+manager.dir)
+		assert.Contains(t, first.Rsh, "-o ControlMaster=no")
+		assert.NoError(t, manager.Check(endpoint))
+
+		second, err := manager.Options(endpoint, RsyncOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, first.Rsh, second.Rsh)
+
+		calls, err := os.ReadFile(filepath.Join(filepath.Dir(ssh), "calls"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(calls), " -M "))
+
+		assert.NoError(t, manager.Close())
+		assert.True(t, errors.Is(manager.Check(endpoint), ErrMasterNotStarted))
+		_, err = os.Stat(manager.dir)
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+
+		_, err = manager.Options(endpoint, RsyncOptions{})
+		assert.Equal(t, ErrConnectionManagerClosed, err)
+	})
+
+	t.Run("restarts dead master", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeMasterSSH)
+		manager, err := NewConnectionManager(ConnectionOptions{SSH: SSHOptions{BinaryPath: ssh}})
+		assert.NoError(t, err)
+		defer func() {
+			_ = manager.Close()
+		}()
+
+		_, err = manager.Options(endpoint, RsyncOptions{})
+		assert.NoError(t, err)
+		mst := currentMaster(manager, endpoint)
+		assert.NoError(t, os.Remove(mst.socket))
+		<-mst.exited
+
+		_, err = manager.Options(endpoint, RsyncOptions{})
+		assert.NoError(t, err)
+		assert.NoError(t, manager.Check(endpoint))
+		assert.NotEqual(t, mst, currentMaster(manager, endpoint))
+	})
+
+	t.Run("stops idle masters", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeMasterSSH)
+		manager, err := NewConnectionManager(ConnectionOptions{SSH: SSHOptions{BinaryPath: ssh}, IdleTimeout: 100 * time.Millisecond})
+		assert.NoError(t, err)
+		defer func() {
+			_ = manager.Close()
+		}()
+
+		_, err = manager.Options(endpoint, RsyncOptions{})
+		assert.NoError(t, err)
+		mst := currentMaster(manager, endpoint)
+
+		select {
+		case <-mst.exited:
+		case <-time.After(2 * time.Second):
+			t.Fatal("idle master is not stopped")
+		}
+		assert.True(t, errors.Is(manager.Check(endpoint), ErrMasterNotStarted))
+	})
+
+	t.Run("hosts don't wait for each other", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeMasterSSH)
+		manager, err := NewConnectionManager(ConnectionOptions{SSH: SSHOptions{BinaryPath: ssh}})
+		assert.NoError(t, err)
+		defer func() {
+			_ = manager.Close()
+		}()
+
+		slowStarted := make(chan error)
+		go func() {
+			_, err := manager.Options(Endpoint{Host: "slow"}, RsyncOptions{})
+			slowStarted <- err
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		started := time.Now()
+		_, err = manager.Options(endpoint, RsyncOptions{})
+		assert.NoError(t, err)
+		assert.Less(t, int64(time.Since(started)), int64(700*time.Millisecond))
+		assert.NoError(t, <-slowStarted)
+	})
+
+	t.Run("shared master is not idle", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeMasterSSH)
+		manager, err := NewConnectionManager(ConnectionOptions{SSH: SSHOptions{BinaryPath: ssh}, IdleTimeout: 100 * time.Millisecond})
+		assert.NoError(t, err)
+		defer func() {
+			_ = manager.Close()
+		}()
+
+		binary := writeFakeRsync(t, recordingRsync+"sleep 0.5\n")
+		task := NewTask([]string{"src/"}, "backup@target:/dst", RsyncOptions{RsyncBinaryPath: binary})
+		task.Use(manager.Share(endpoint))
+		assert.NoError(t, task.Run())
+		mst := currentMaster(manager, endpoint)
+		select {
+		case <-mst.exited:
+			t.Fatal("master is stopped during the run")
+		default:
+		}
+		assert.Contains(t, readCalls(t, binary)[0], "ControlPath="+mst.socket)
+
+		select {
+		case <-mst.exited:
+		case <-time.After(2 * time.Second):
+			t.Fatal("idle master is not stopped after the run")
+		}
+	})
+
+	t.Run("unreachable host", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeMasterSSH)
+		manager, err := NewConnectionManager(ConnectionOptions{SSH: SSHOptions{BinaryPath: ssh}})
+		assert.NoError(t, err)
+		defer func() {
+			_ = manager.Close()
+		}()
+
+		_, err = manager.Options(Endpoint{Host: "unreachable"}, RsyncOptions{})
+		assert.Equal(t, ErrMasterNotStarted, err)
+	})
+}
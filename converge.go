@@ -0,0 +1,95 @@
+package grsync
+
+import (
+	"errors"
+	"time"
+)
+
+const defaultMaxPasses = 5
+
+// ErrNotConverged is returned by Converge when passes limit is reached before the tree became stable
+var ErrNotConverged = errors.New("rsync: tree did not converge")
+
+// ConvergeOptions for Converge
+type ConvergeOptions struct {
+	// MaxPasses limits the number of passes including the final one; by default 5
+	MaxPasses int
+	// MinFiles a pass transferred fewer files than MinFiles is considered stable
+	MinFiles int
+	// MinBytes a pass transferred fewer bytes than MinBytes is considered stable
+	MinBytes int64
+	// Freeze is called before the final pass, e.g. stop the app or flush the DB
+	Freeze func() error
+	// Thaw is called after the final pass, even if it failed
+	Thaw func() error
+}
+
+// PassStats contains information about one Converge pass
+type PassStats struct {
+	Pass     int           `json:"pass"`
+	Frozen   bool          `json:"frozen"`
+	Duration time.Duration `json:"duration"`
+	Stats    Stats         `json:"stats"`
+}
+
+// Converge reruns rsync task until a pass transfers less than configured thresholds.
+// When Freeze hook is set one more pass is done between Freeze and Thaw calls.
+func Converge(source []string, destination string, rsyncOptions RsyncOptions, options ConvergeOptions) ([]PassStats, error) {
+	maxPasses := options.MaxPasses
+	if maxPasses <= 0 {
+		maxPasses = defaultMaxPasses
+	}
+	rsyncOptions.Stats = true
+
+	var passes []PassStats
+	for pass := 1; pass <= maxPasses; pass++ {
+		frozen := options.Freeze != nil && (pass == maxPasses || (len(passes) > 0 && options.stable(passes[len(passes)-1].Stats)))
+		if frozen {
+			if err := options.Freeze(); err != nil {
+				return passes, err
+			}
+		}
+
+		stats, err := runPass(pass, frozen, source, destination, rsyncOptions)
+		passes = append(passes, stats)
+
+		if frozen {
+			if options.Thaw != nil {
+				if thawErr := options.Thaw(); err == nil {
+					err = thawErr
+				}
+			}
+			return passes, err
+		}
+		if err != nil {
+			return passes, err
+		}
+		if options.Freeze == nil && options.stable(stats.Stats) {
+			return passes, nil
+		}
+	}
+
+	return passes, ErrNotConverged
+}
+
+func runPass(pass int, frozen bool, source []string, destination string, rsyncOptions RsyncOptions) (PassStats, error) {
+	startedAt := time.Now()
+	task := NewTask(source, destination, rsyncOptions)
+	err := task.Run()
+
+	return PassStats{
+		Pass:     pass,
+		Frozen:   frozen,
+		Duration: time.Since(startedAt),
+		Stats:    task.Stats(),
+	}, err
+}
+
+func (o ConvergeOptions) stable(stats Stats) bool {
+	if o.MinFiles <= 0 && o.MinBytes <= 0 {
+		return stats.FilesTransferred == 0
+	}
+
+	return (o.MinFiles > 0 && stats.FilesTransferred < o.MinFiles) ||
+		(o.MinBytes > 0 && stats.TransferredSize < o.MinBytes)
+}
@@ -0,0 +1,98 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// halvingRsync transfers half of the files left from the previous pass
+const halvingRsync = `count="$(dirname "$0")/count"
+n=$(cat "$count")
+echo $((n / 2)) > "$count"
+echo "Number of regular files transferred: $n"
+echo "Total transferred file size: $((n * 1000)) bytes"
+`
+
+func newHalvingRsync(t *testing.T, files int) string {
+	binary := writeFakeRsync(t, halvingRsync)
+	count := filepath.Join(filepath.Dir(binary), "count")
+	if err := os.WriteFile(count, []byte(strconv.Itoa(files)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return binary
+}
+
+func TestConverge(t *testing.T) {
+	t.Run("stops when nothing transferred", func(t *testing.T) {
+		binary := newHalvingRsync(t, 8)
+		passes, err := Converge([]string{"a"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}, ConvergeOptions{})
+
+		assert.NoError(t, err)
+		assert.Len(t, passes, 5)
+		assert.Equal(t, 8, passes[0].Stats.FilesTransferred)
+		assert.Equal(t, 0, passes[4].Stats.FilesTransferred)
+	})
+
+	t.Run("stops below threshold", func(t *testing.T) {
+		binary := newHalvingRsync(t, 8)
+		passes, err := Converge([]string{"a"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}, ConvergeOptions{MinBytes: 3000})
+
+		assert.NoError(t, err)
+		assert.Len(t, passes, 3)
+		assert.Equal(t, int64(2000), passes[2].Stats.TransferredSize)
+	})
+
+	t.Run("freezes before the final pass", func(t *testing.T) {
+		binary := newHalvingRsync(t, 8)
+		var calls []string
+		passes, err := Converge([]string{"a"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}, ConvergeOptions{
+			MinFiles: 3,
+			Freeze: func() error {
+				calls = append(calls, "freeze")
+				return nil
+			},
+			Thaw: func() error {
+				calls = append(calls, "thaw")
+				return nil
+			},
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"freeze", "thaw"}, calls)
+		assert.Len(t, passes, 4)
+		assert.False(t, passes[2].Frozen)
+		assert.True(t, passes[3].Frozen)
+		assert.Equal(t, 1, passes[3].Stats.FilesTransferred)
+	})
+
+	t.Run("freezes on the last allowed pass", func(t *testing.T) {
+		binary := newHalvingRsync(t, 1000)
+		frozen := false
+		passes, err := Converge([]string{"a"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}, ConvergeOptions{
+			MaxPasses: 2,
+			Freeze: func() error {
+				frozen = true
+				return nil
+			},
+		})
+
+		assert.NoError(t, err)
+		assert.True(t, frozen)
+		assert.Len(t, passes, 2)
+		assert.True(t, passes[1].Frozen)
+	})
+
+	t.Run("reports not converged", func(t *testing.T) {
+		binary := newHalvingRsync(t, 1000)
+		passes, err := Converge([]string{"a"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}, ConvergeOptions{MaxPasses: 2})
+
+		assert.True(t, errors.Is(err, ErrNotConverged))
+		assert.Len(t, passes, 2)
+	})
+}
@@ -0,0 +1,257 @@
+package grsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DedupeOptions for Dedupe. By default only files with equal mode, owner and modification time are linked.
+type DedupeOptions struct {
+	// IgnoreMode links files with different permissions
+	IgnoreMode bool
+	// IgnoreOwner links files with different owner and group
+	IgnoreOwner bool
+	// IgnoreModTime links files with different modification time
+	IgnoreModTime bool
+	// MinSize skips smaller files; by default empty files are skipped
+	MinSize int64
+	// Wait waits for the destination lock instead of failing with ErrDestinationLocked
+	Wait bool
+	// Cache keeps checksums of candidates between runs
+	Cache *ChecksumCache
+}
+
+// DedupeReport is a result of Dedupe
+type DedupeReport struct {
+	// Files is a number of checked regular files
+	Files int `json:"files"`
+	// Linked is a number of files replaced by hard links
+	Linked int `json:"linked"`
+	// ReclaimedBytes is a size of data freed by linking
+	ReclaimedBytes int64 `json:"reclaimed bytes"`
+	// Cache counts checksums taken from Cache option
+	Cache CacheStats `json:"cache"`
+}
+
+// inode identifies file data and its owner
+type inode struct {
+	valid bool
+	dev   uint64
+	ino   uint64
+	nlink uint64
+	uid   uint32
+	gid   uint32
+}
+
+// dedupeFile is a candidate of Dedupe
+type dedupeFile struct {
+	path  string
+	info  os.FileInfo
+	inode inode
+}
+
+// Dedupe replaces identical files under root with hard links. Candidates are grouped by size and
+// metadata, hashed and compared byte by byte. Root is locked with the destination lock while it runs.
+func Dedupe(root string, options DedupeOptions) (report DedupeReport, err error) {
+	if options.MinSize <= 0 {
+		options.MinSize = 1
+	}
+
+	lock, err := LockDestination(root, options.Wait)
+	if err != nil {
+		return DedupeReport{}, err
+	}
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	groups := map[string][]dedupeFile{}
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		report.Files++
+		if info.Size() < options.MinSize {
+			return nil
+		}
+		file := dedupeFile{path: path, info: info, inode: inodeOf(info)}
+		key := options.groupKey(file)
+		groups[key] = append(groups[key], file)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := hashFile
+	if options.Cache != nil {
+		before := options.Cache.Stats()
+		defer func() {
+			report.Cache = options.Cache.Stats().sub(before)
+		}()
+		hash = options.Cache.Sum
+	}
+
+	unlinked := map[[2]uint64]uint64{}
+	for _, key := range keys {
+		if err = dedupeGroup(groups[key], hash, &report, unlinked); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// groupKey returns key of files which may be linked to each other
+func (o DedupeOptions) groupKey(file dedupeFile) string {
+	key := strconv.FormatInt(file.info.Size(), 10) + ":" + strconv.FormatUint(file.inode.dev, 10)
+	if !o.IgnoreMode {
+		key += ":" + file.info.Mode().String()
+	}
+	if !o.IgnoreOwner {
+		key += ":" + strconv.FormatUint(uint64(file.inode.uid), 10) + ":" + strconv.FormatUint(uint64(file.inode.gid), 10)
+	}
+	if !o.IgnoreModTime {
+		key += ":" + file.info.ModTime().UTC().Format(time.RFC3339Nano)
+	}
+	return key
+}
+
+// dedupeGroup links files of the same size and metadata with equal content;
+// unlinked counts replaced links of every inode, its data is freed when all links are replaced
+func dedupeGroup(files []dedupeFile, hash func(path string) (string, error), report *DedupeReport, unlinked map[[2]uint64]uint64) error {
+	if len(files) < 2 {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+
+	byHash := map[string][]dedupeFile{}
+	var hashes []string
+	for _, file := range files {
+		sum, err := hash(file.path)
+		if err != nil {
+			return err
+		}
+		if _, ok := byHash[sum]; !ok {
+			hashes = append(hashes, sum)
+		}
+		byHash[sum] = append(byHash[sum], file)
+	}
+
+	for _, sum := range hashes {
+		same := byHash[sum]
+		target := same[0]
+		for _, file := range same[1:] {
+			if file.inode.valid && file.inode.dev == target.inode.dev && file.inode.ino == target.inode.ino {
+				continue
+			}
+
+			equal, err := sameContent(target.path, file.path)
+			if err != nil {
+				return err
+			}
+			if !equal {
+				continue
+			}
+
+			if err = replaceWithLink(target.path, file.path); err != nil {
+				return err
+			}
+			report.Linked++
+			id := [2]uint64{file.inode.dev, file.inode.ino}
+			unlinked[id]++
+			if unlinked[id] >= file.inode.nlink {
+				report.ReclaimedBytes += file.info.Size()
+			}
+		}
+	}
+	return nil
+}
+
+// replaceWithLink atomically replaces path with a hard link to target
+func replaceWithLink(target, path string) error {
+	temp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".dedupe")
+	_ = os.Remove(temp)
+	if err := os.Link(target, temp); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sameContent compares files byte by byte
+func sameContent(first, second string) (bool, error) {
+	const chunkSize = 64 * 1024
+
+	a, err := os.Open(first)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = a.Close()
+	}()
+	b, err := os.Open(second)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = b.Close()
+	}()
+
+	bufA, bufB := make([]byte, chunkSize), make([]byte, chunkSize)
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		nB, errB := io.ReadFull(b, bufB)
+		if nA != nB || !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package grsync
+
+import (
+	"os"
+)
+
+func inodeOf(info os.FileInfo) inode {
+	return inode{}
+}
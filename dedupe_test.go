@@ -0,0 +1,89 @@
+package grsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupe(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "archive")
+	mtime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	content := []byte("identical content")
+	write := func(name string, data []byte, mode os.FileMode, modTime time.Time) {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, data, mode))
+		require.NoError(t, os.Chmod(path, mode))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	write("a/x.bin", content, 0644, mtime)
+	write("b/x.bin", content, 0644, mtime)
+	write("c/y.bin", content, 0644, mtime)
+	write("d/other.bin", []byte("different content"), 0644, mtime)
+	write("e/newer.bin", content, 0644, mtime.Add(time.Hour))
+	write("f/private.bin", content, 0600, mtime)
+	write("g/empty", nil, 0644, mtime)
+	write("h/empty", nil, 0644, mtime)
+
+	same := func(first, second string) bool {
+		a, err := os.Stat(filepath.Join(root, first))
+		require.NoError(t, err)
+		b, err := os.Stat(filepath.Join(root, second))
+		require.NoError(t, err)
+		return os.SameFile(a, b)
+	}
+
+	report, err := Dedupe(root, DedupeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 8, report.Files)
+	assert.Equal(t, 2, report.Linked)
+	assert.Equal(t, int64(2*len(content)), report.ReclaimedBytes)
+	assert.True(t, same("a/x.bin", "b/x.bin"))
+	assert.True(t, same("a/x.bin", "c/y.bin"))
+	assert.False(t, same("a/x.bin", "d/other.bin"))
+	assert.False(t, same("a/x.bin", "e/newer.bin"))
+	assert.False(t, same("a/x.bin", "f/private.bin"))
+	assert.False(t, same("g/empty", "h/empty"))
+
+	report, err = Dedupe(root, DedupeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Linked)
+
+	report, err = Dedupe(root, DedupeOptions{IgnoreMode: true, IgnoreModTime: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Linked)
+	assert.True(t, same("e/newer.bin", "f/private.bin"))
+
+	lock, err := LockDestination(root, false)
+	require.NoError(t, err)
+	_, err = Dedupe(root, DedupeOptions{})
+	assert.Equal(t, ErrDestinationLocked, err)
+	require.NoError(t, lock.Unlock())
+}
+
+func TestLock(t *testing.T) {
+	binary := writeFakeRsync(t, "")
+	destination := filepath.Join(t.TempDir(), "dst")
+
+	lock, err := LockDestination(destination, false)
+	require.NoError(t, err)
+	task := NewTask([]string{"src/"}, destination, RsyncOptions{RsyncBinaryPath: binary})
+	task.Use(Lock(false))
+	assert.Equal(t, ErrDestinationLocked, task.Run())
+
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = lock.Unlock()
+		close(released)
+	}()
+	task = NewTask([]string{"src/"}, destination, RsyncOptions{RsyncBinaryPath: binary})
+	task.Use(Lock(true))
+	assert.NoError(t, task.Run())
+	<-released
+}
@@ -0,0 +1,24 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package grsync
+
+import (
+	"os"
+	"syscall"
+)
+
+func inodeOf(info os.FileInfo) inode {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inode{}
+	}
+	return inode{
+		valid: true,
+		dev:   uint64(sys.Dev),
+		ino:   uint64(sys.Ino),
+		nlink: uint64(sys.Nlink),
+		uid:   sys.Uid,
+		gid:   sys.Gid,
+	}
+}
@@ -0,0 +1,288 @@
+package grsync
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tailInterval = 200 * time.Millisecond
+
+// ErrExitCodeLost is returned when detached process is killed before its exit code is saved
+var ErrExitCodeLost = errors.New("rsync: detached process exited without exit code")
+
+// DetachedState is saved in the state file of a detached task
+type DetachedState struct {
+	PID int `json:"pid"`
+	// StartTime is the process start time in clock ticks after boot, it tells a reused PID apart
+	StartTime   uint64       `json:"start time"`
+	StartedAt   time.Time    `json:"started at"`
+	Source      []string     `json:"source"`
+	Destination string       `json:"destination"`
+	Options     RsyncOptions `json:"options"`
+	Stdout      string       `json:"stdout"`
+	Stderr      string       `json:"stderr"`
+	ExitCode    string       `json:"exit code"`
+}
+
+// DetachedTask is rsync running in its own session, so it outlives the process which started it.
+// Its output goes to files next to the state file.
+type DetachedTask struct {
+	state DetachedState
+	task  *Task
+
+	once   sync.Once
+	exited chan struct{}
+}
+
+// Detach starts rsync in its own session and saves its PID and options in stateFile.
+// RsyncContext is ignored, the process is not bound to the caller.
+func Detach(stateFile string, source []string, destination string, rsyncOptions RsyncOptions) (*DetachedTask, error) {
+	rsyncOptions = forceOptions(rsyncOptions)
+	rsyncOptions.RsyncContext = nil
+
+	state := DetachedState{
+		Source:      source,
+		Destination: destination,
+		Options:     rsyncOptions,
+		Stdout:      stateFile + ".stdout",
+		Stderr:      stateFile + ".stderr",
+		ExitCode:    stateFile + ".exit",
+	}
+	_ = os.Remove(state.ExitCode)
+
+	stdout, err := os.Create(state.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = stdout.Close()
+	}()
+	stderr, err := os.Create(state.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = stderr.Close()
+	}()
+
+	rsync := NewRsync(source, destination, rsyncOptions)
+	if err = detachCommand(rsync.cmd, state.ExitCode); err != nil {
+		return nil, err
+	}
+	rsync.cmd.Stdout = stdout
+	rsync.cmd.Stderr = stderr
+	if err = rsync.start(); err != nil {
+		return nil, err
+	}
+
+	state.PID = rsync.cmd.Process.Pid
+	state.StartedAt = time.Now()
+	state.StartTime, _ = processStartTime(state.PID)
+
+	detached := newDetachedTask(state)
+	go func() {
+		// the starter reaps its child, after its restart init does it
+		_ = rsync.cmd.Wait()
+		detached.markExited()
+	}()
+
+	if err = saveDetachedState(stateFile, state); err != nil {
+		// the shell wrapper is the session leader, rsync and ssh under it are killed too
+		_ = killSession(state.PID)
+		return nil, err
+	}
+
+	return detached, nil
+}
+
+// Reattach loads state file of a detached task, e.g. started before the supervisor restart
+func Reattach(stateFile string) (*DetachedTask, error) {
+	if err := detachSupported(); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	var state DetachedState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	detached := newDetachedTask(state)
+	go func() {
+		waitProcess(state.PID, state.StartTime)
+		detached.markExited()
+	}()
+
+	return detached, nil
+}
+
+func newDetachedTask(state DetachedState) *DetachedTask {
+	return &DetachedTask{
+		state:  state,
+		task:   NewTaskWithoutForceOptions(state.Source, state.Destination, state.Options),
+		exited: make(chan struct{}),
+	}
+}
+
+func (d *DetachedTask) SetStdout(stdout io.Writer) {
+	d.task.SetStdout(stdout)
+}
+
+func (d *DetachedTask) SetStderr(stderr io.Writer) {
+	d.task.SetStderr(stderr)
+}
+
+// OnEvent adds listener called for every task event
+func (d *DetachedTask) OnEvent(listener func(event Event)) {
+	d.task.OnEvent(listener)
+}
+
+// DetachedState returns saved state of the task
+func (d *DetachedTask) DetachedState() DetachedState {
+	return d.state
+}
+
+// State returns information about rsync process parsed from its output
+func (d *DetachedTask) State() State {
+	return d.task.State()
+}
+
+// Log returns outputs of rsync process
+func (d *DetachedTask) Log() Log {
+	return d.task.Log()
+}
+
+// Stats returns transfer statistics of rsync process
+func (d *DetachedTask) Stats() Stats {
+	return d.task.Stats()
+}
+
+// Exited is closed when the process exits
+func (d *DetachedTask) Exited() <-chan struct{} {
+	return d.exited
+}
+
+// Wait parses output files from the beginning following them until the process exits,
+// then returns rsync result
+func (d *DetachedTask) Wait() (err error) {
+	defer func() {
+		stats := d.task.Stats()
+		d.task.emit(Event{Type: EventResult, Stats: &stats, Err: err})
+	}()
+
+	stdout, err := os.Open(d.state.Stdout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stdout.Close()
+	}()
+	stderr, err := os.Open(d.state.Stderr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stderr.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		processStdout(d.task, &tailReader{file: stdout, exited: d.exited})
+		wg.Done()
+	}()
+	go func() {
+		processStderr(d.task, &tailReader{file: stderr, exited: d.exited})
+		wg.Done()
+	}()
+	wg.Wait()
+
+	data, err := os.ReadFile(d.state.ExitCode)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrExitCodeLost
+	}
+	if err != nil {
+		return err
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return exitError(code, d.task.log.Stderr, nil)
+	}
+	return nil
+}
+
+func (d *DetachedTask) markExited() {
+	d.once.Do(func() {
+		close(d.exited)
+	})
+}
+
+// detachCommand runs the command through shell which saves its exit code, the exit status of
+// a process which isn't a child is not available to the reattached supervisor
+func detachCommand(cmd *exec.Cmd, exitCodePath string) error {
+	shell, err := exec.LookPath("sh")
+	if err != nil {
+		return err
+	}
+	if err = startSession(cmd); err != nil {
+		return err
+	}
+
+	temp := shellQuote(exitCodePath + ".tmp")
+	script := `"$@"; echo $? > ` + temp + ` && mv ` + temp + ` ` + shellQuote(exitCodePath)
+	cmd.Args = append([]string{"sh", "-c", script, "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = shell
+	return nil
+}
+
+func saveDetachedState(path string, state DetachedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	temp := path + ".tmp"
+	if err = os.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// tailReader follows a growing file until exited is closed
+type tailReader struct {
+	file   *os.File
+	exited <-chan struct{}
+	final  bool
+}
+
+func (r *tailReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.file.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		if r.final {
+			return 0, io.EOF
+		}
+
+		select {
+		case <-r.exited:
+			// read once more what was written before exit
+			r.final = true
+		case <-time.After(tailInterval):
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sysPidfdOpen is the pidfd_open syscall number; it's 434 everywhere except mips ABIs, which offset it
+var sysPidfdOpen = func() uintptr {
+	switch runtime.GOARCH {
+	case "mips", "mipsle":
+		return 4434
+	case "mips64", "mips64le":
+		return 5434
+	}
+	return 434
+}()
+
+func detachSupported() error {
+	return nil
+}
+
+// startSession makes the command a session leader, so it doesn't get signals of the starter's terminal
+func startSession(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	return nil
+}
+
+// killSession kills all processes of the session started by startSession
+func killSession(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// processStartTime returns start time of the process from /proc/PID/stat
+func processStartTime(pid int) (uint64, error) {
+	const startTimeField = 19 // the 22nd field counting from the state after the command name
+
+	fields, err := processStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(fields[startTimeField], 10, 64)
+}
+
+// processStat returns fields of /proc/PID/stat after the command name
+func processStat(pid int) ([]string, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	// the command name may contain spaces and parentheses
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return nil, errors.New("rsync: unexpected format of process stat")
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return nil, errors.New("rsync: unexpected format of process stat")
+	}
+	return fields, nil
+}
+
+// waitProcess waits until the process, which may be not a child, exits.
+// The process with other start time is treated as exited, its PID was reused.
+func waitProcess(pid int, startTime uint64) {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno != 0 {
+		if errno == syscall.ESRCH {
+			return
+		}
+		// kernels before 5.3 have no pidfd
+		pollProcess(pid, startTime)
+		return
+	}
+	pidfd := int(fd)
+	defer func() {
+		_ = syscall.Close(pidfd)
+	}()
+
+	if !sameProcess(pid, startTime) {
+		return
+	}
+
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		pollProcess(pid, startTime)
+		return
+	}
+	defer func() {
+		_ = syscall.Close(epfd)
+	}()
+
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(pidfd)}
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, pidfd, &event); err != nil {
+		pollProcess(pid, startTime)
+		return
+	}
+
+	// pidfd becomes readable when the process exits
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		n, err := syscall.EpollWait(epfd, events, -1)
+		if n > 0 || err != nil && err != syscall.EINTR {
+			return
+		}
+	}
+}
+
+// pollProcess checks the process periodically
+func pollProcess(pid int, startTime uint64) {
+	for sameProcess(pid, startTime) {
+		time.Sleep(tailInterval)
+	}
+}
+
+// sameProcess reports whether the process with the saved start time is alive and is not a zombie
+func sameProcess(pid int, startTime uint64) bool {
+	fields, err := processStat(pid)
+	if err != nil || fields[0] == "Z" {
+		return false
+	}
+	return startTime == 0 || fields[19] == strconv.FormatUint(startTime, 10)
+}
@@ -0,0 +1,121 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// detachedRsync waits for the go file and exits with the code from it
+const detachedRsync = `dir="$(dirname "$0")"
+echo "sending incremental file list"
+while [ ! -f "$dir/go" ]; do sleep 0.05; done
+echo "data/a.jpg"
+echo "      1,000 100%  500.00kB/s    0:00:00 (xfr#1, to-chk=0/2)"
+echo "Number of regular files transferred: 1"
+echo "some warning" >&2
+exit "$(cat "$dir/go")"
+`
+
+func TestDetach(t *testing.T) {
+	release := func(binary, code string) {
+		require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(binary), "go"), []byte(code), 0600))
+	}
+
+	t.Run("wait", func(t *testing.T) {
+		binary := writeFakeRsync(t, detachedRsync)
+		stateFile := filepath.Join(t.TempDir(), "job.json")
+		task, err := Detach(stateFile, []string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary})
+		require.NoError(t, err)
+
+		state := task.DetachedState()
+		assert.NotZero(t, state.PID)
+		assert.NotZero(t, state.StartTime)
+		assert.True(t, state.Options.Progress)
+
+		release(binary, "0")
+		require.NoError(t, task.Wait())
+		assert.Equal(t, 1, task.Stats().FilesTransferred)
+		assert.Equal(t, float64(100), task.State().Progress)
+		assert.Equal(t, "some warning\n", task.Log().Stderr)
+	})
+
+	t.Run("reattach", func(t *testing.T) {
+		binary := writeFakeRsync(t, detachedRsync)
+		stateFile := filepath.Join(t.TempDir(), "job.json")
+		started, err := Detach(stateFile, []string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary})
+		require.NoError(t, err)
+
+		task, err := Reattach(stateFile)
+		require.NoError(t, err)
+		assert.Equal(t, started.DetachedState().PID, task.DetachedState().PID)
+
+		select {
+		case <-task.Exited():
+			t.Fatal("process is reported exited too early")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		release(binary, "23")
+		err = task.Wait()
+		var rsyncErr *RsyncError
+		require.True(t, errors.As(err, &rsyncErr))
+		assert.Equal(t, 23, rsyncErr.Code)
+		assert.Equal(t, "some warning", rsyncErr.Message)
+		assert.Equal(t, 1, task.Stats().FilesTransferred)
+	})
+
+	t.Run("state not saved", func(t *testing.T) {
+		binary := writeFakeRsync(t, `echo $$ > "$(dirname "$0")/pid"; sleep 10`)
+		pidFile := filepath.Join(filepath.Dir(binary), "pid")
+		// the state is written to a FIFO, so it's saved only after rsync started,
+		// and renaming it over a directory fails
+		stateFile := t.TempDir()
+		require.NoError(t, syscall.Mkfifo(stateFile+".tmp", 0600))
+		go func() {
+			for {
+				if _, err := os.Stat(pidFile); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			_, _ = os.ReadFile(stateFile + ".tmp")
+		}()
+
+		_, err := Detach(stateFile, []string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary})
+		require.Error(t, err)
+
+		data, err := os.ReadFile(pidFile)
+		require.NoError(t, err)
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return !sameProcess(pid, 0)
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("reused pid", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "job.json")
+		require.NoError(t, saveDetachedState(stateFile, DetachedState{
+			PID:       os.Getpid(),
+			StartTime: 1,
+			Stdout:    stateFile + ".stdout",
+			Stderr:    stateFile + ".stderr",
+			ExitCode:  stateFile + ".exit",
+		}))
+		require.NoError(t, os.WriteFile(stateFile+".stdout", nil, 0600))
+		require.NoError(t, os.WriteFile(stateFile+".stderr", nil, 0600))
+
+		task, err := Reattach(stateFile)
+		require.NoError(t, err)
+		assert.Equal(t, ErrExitCodeLost, task.Wait())
+	})
+}
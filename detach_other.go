@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package grsync
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errDetachUnsupported = errors.New("rsync: detached tasks are supported only on linux")
+
+func detachSupported() error {
+	return errDetachUnsupported
+}
+
+func startSession(cmd *exec.Cmd) error {
+	return errDetachUnsupported
+}
+
+func killSession(pid int) error {
+	return errDetachUnsupported
+}
+
+func processStartTime(pid int) (uint64, error) {
+	return 0, errDetachUnsupported
+}
+
+func waitProcess(pid int, startTime uint64) {}
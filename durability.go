@@ -0,0 +1,111 @@
+package grsync
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Durability groups measures which keep synced files intact after a crash or power loss
+type Durability struct {
+	// Fsync makes rsync fsync every written file; it needs rsync 3.2.4 or newer on both sides,
+	// older versions fail with an unknown option error
+	Fsync bool `json:"fsync"`
+	// DelayUpdates puts updated files into place at the end of the transfer
+	DelayUpdates bool `json:"delay updates"`
+	// Preallocate allocates files before writing, so a full disk fails the transfer early
+	Preallocate bool `json:"preallocate"`
+	// TempDir is a directory for temporary files; a relative one is inside the destination,
+	// so renames stay on the same filesystem. By default temporary files are next to the final ones.
+	TempDir string `json:"temp dir,omitempty"`
+	// SyncDirs fsyncs directories of a local destination after a successful run, so renames are durable
+	SyncDirs bool `json:"sync dirs"`
+}
+
+// Durable returns Durability with all measures enabled, so it needs rsync 3.2.4 or newer
+func Durable() *Durability {
+	return &Durability{
+		Fsync:        true,
+		DelayUpdates: true,
+		Preallocate:  true,
+		SyncDirs:     true,
+	}
+}
+
+// Guarantees lists crash safety measures which were in effect for a run
+type Guarantees struct {
+	Fsync        bool   `json:"fsync"`
+	DelayUpdates bool   `json:"delay updates"`
+	Preallocate  bool   `json:"preallocate"`
+	TempDir      string `json:"temp dir,omitempty"`
+	// DirsSynced reports whether destination directories were fsynced after the run
+	DirsSynced bool `json:"dirs synced"`
+}
+
+// Guarantees returns crash safety measures in effect for the last run; they are reported
+// only after a successful run, otherwise it's empty
+func (t *Task) Guarantees() Guarantees {
+	if !t.succeeded {
+		return Guarantees{}
+	}
+	options := t.options.Durability.apply(t.options)
+	return Guarantees{
+		Fsync:        options.Fsync,
+		DelayUpdates: options.DelayUpdates,
+		Preallocate:  options.Preallocate,
+		TempDir:      options.TempDir,
+		DirsSynced:   t.dirsSynced,
+	}
+}
+
+// apply sets rsync options of the enabled measures
+func (d *Durability) apply(options RsyncOptions) RsyncOptions {
+	if d == nil {
+		return options
+	}
+
+	options.Fsync = options.Fsync || d.Fsync
+	options.DelayUpdates = options.DelayUpdates || d.DelayUpdates
+	options.Preallocate = options.Preallocate || d.Preallocate
+	if d.TempDir != "" {
+		options.TempDir = d.TempDir
+	}
+	return options
+}
+
+// syncDirs fsyncs the destination, its subdirectories and its parent
+func (t *Task) syncDirs() error {
+	durability := t.options.Durability
+	destination := t.rsync.Destination
+	if durability == nil || !durability.SyncDirs || t.options.DryRun || destination == "" || isRemotePath(destination) {
+		return nil
+	}
+
+	err := filepath.WalkDir(destination, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		return syncDir(path)
+	})
+	if err != nil {
+		return err
+	}
+	if err = syncDir(filepath.Dir(filepath.Clean(destination))); err != nil {
+		return err
+	}
+
+	t.dirsSynced = true
+	return nil
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err = dir.Sync(); err != nil {
+		_ = dir.Close()
+		return err
+	}
+	return dir.Close()
+}
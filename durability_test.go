@@ -0,0 +1,60 @@
+package grsync
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurability(t *testing.T) {
+	t.Run("arguments", func(t *testing.T) {
+		args := getArguments(RsyncOptions{Durability: Durable()})
+		assert.ElementsMatch(t, args, []string{"--fsync", "--delay-updates", "--preallocate"})
+
+		args = getArguments(RsyncOptions{Durability: &Durability{TempDir: ".tmp"}})
+		assert.ElementsMatch(t, args, []string{"--temp-dir", ".tmp"})
+	})
+
+	t.Run("local", func(t *testing.T) {
+		binary := writeFakeRsync(t, "")
+		destination := filepath.Join(t.TempDir(), "dst")
+		writeTree(t, destination, "a/b/file")
+
+		var result *Guarantees
+		task := NewTask([]string{"src/"}, destination, RsyncOptions{RsyncBinaryPath: binary, Durability: Durable()})
+		task.OnEvent(func(event Event) {
+			if event.Type == EventResult {
+				result = event.Guarantees
+			}
+		})
+		require.NoError(t, task.Run())
+
+		expected := Guarantees{Fsync: true, DelayUpdates: true, Preallocate: true, DirsSynced: true}
+		assert.Equal(t, expected, task.Guarantees())
+		assert.Equal(t, &expected, result)
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		binary := writeFakeRsync(t, "")
+		task := NewTask([]string{"src/"}, "host:/dst", RsyncOptions{RsyncBinaryPath: binary, Durability: Durable()})
+		require.NoError(t, task.Run())
+		assert.False(t, task.Guarantees().DirsSynced)
+		assert.True(t, task.Guarantees().Fsync)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		binary := writeFakeRsync(t, "exit 23")
+		task := NewTask([]string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary, Durability: Durable()})
+		require.Error(t, task.Run())
+		assert.Equal(t, Guarantees{}, task.Guarantees())
+	})
+
+	t.Run("without durability", func(t *testing.T) {
+		binary := writeFakeRsync(t, "")
+		task := NewTask([]string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary})
+		require.NoError(t, task.Run())
+		assert.Equal(t, Guarantees{}, task.Guarantees())
+	})
+}
@@ -0,0 +1,95 @@
+package grsync
+
+import (
+	"strconv"
+	"strings"
+)
+
+const defaultRsh = "ssh"
+
+// Endpoint describes a location of synced files; empty Host means local path
+type Endpoint struct {
+	// User is a login on the remote host
+	User string `json:"user"`
+	// Host is a remote host name or address
+	Host string `json:"host"`
+	// Port is ssh or rsync daemon port; by default the standard one
+	Port int `json:"port"`
+	// Path is a path on the host or a module path for the rsync daemon
+	Path string `json:"path"`
+	// Daemon connects to rsync daemon instead of remote shell
+	Daemon bool `json:"daemon"`
+}
+
+// IsLocal reports whether endpoint is a local path
+func (e Endpoint) IsLocal() bool {
+	return e.Host == ""
+}
+
+// Address returns `user@host` part of the endpoint
+func (e Endpoint) Address() string {
+	if e.User == "" {
+		return e.Host
+	}
+	return e.User + "@" + e.Host
+}
+
+// String returns endpoint in rsync notation, e.g. `user@host:/path` or `rsync://host/module`
+func (e Endpoint) String() string {
+	if e.IsLocal() {
+		return e.Path
+	}
+
+	if e.Daemon {
+		address := e.Address()
+		if e.Port > 0 {
+			address += ":" + strconv.Itoa(e.Port)
+		}
+		return "rsync://" + address + "/" + trimLeadingSlash(e.Path)
+	}
+
+	return e.Address() + ":" + e.Path
+}
+
+// Options returns rsync options adjusted to reach the endpoint, e.g. with non-standard port
+func (e Endpoint) Options(options RsyncOptions) RsyncOptions {
+	if e.IsLocal() || e.Daemon || e.Port <= 0 {
+		return options
+	}
+
+	rsh := options.Rsh
+	if rsh == "" {
+		rsh = defaultRsh
+	}
+	options.Rsh = withSSHPort(rsh, e.Port)
+
+	return options
+}
+
+// withSSHPort sets port of the ssh command, a port already set in it is replaced
+func withSSHPort(rsh string, port int) string {
+	fields := strings.Fields(rsh)
+	for i := 1; i < len(fields); i++ {
+		switch {
+		case fields[i] == "-p" && i+1 < len(fields):
+			fields[i+1] = strconv.Itoa(port)
+			return strings.Join(fields, " ")
+		case strings.HasPrefix(fields[i], "-p") && isNumber(fields[i][2:]):
+			fields[i] = "-p" + strconv.Itoa(port)
+			return strings.Join(fields, " ")
+		}
+	}
+	return rsh + " -p " + strconv.Itoa(port)
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func trimLeadingSlash(path string) string {
+	for len(path) > 0 && path[0] == '/' {
+		path = path[1:]
+	}
+	return path
+}
@@ -0,0 +1,38 @@
+package grsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointString(t *testing.T) {
+	assert.Equal(t, "/data", Endpoint{Path: "/data"}.String())
+	assert.Equal(t, "mirror.org:/pub", Endpoint{Host: "mirror.org", Path: "/pub"}.String())
+	assert.Equal(t, "user@mirror.org:/pub", Endpoint{User: "user", Host: "mirror.org", Path: "/pub"}.String())
+	assert.Equal(t, "rsync://mirror.org:8873/pub/debian", Endpoint{Host: "mirror.org", Port: 8873, Path: "/pub/debian", Daemon: true}.String())
+}
+
+func TestEndpointOptions(t *testing.T) {
+	t.Run("default port", func(t *testing.T) {
+		options := Endpoint{Host: "host"}.Options(RsyncOptions{})
+		assert.Empty(t, options.Rsh)
+	})
+
+	t.Run("custom port", func(t *testing.T) {
+		options := Endpoint{Host: "host", Port: 2222}.Options(RsyncOptions{})
+		assert.Equal(t, "ssh -p 2222", options.Rsh)
+	})
+
+	t.Run("custom port with rsh", func(t *testing.T) {
+		options := Endpoint{Host: "host", Port: 2222}.Options(RsyncOptions{Rsh: "ssh -i key"})
+		assert.Equal(t, "ssh -i key -p 2222", options.Rsh)
+	})
+
+	t.Run("rsh with port", func(t *testing.T) {
+		options := Endpoint{Host: "host", Port: 2222}.Options(RsyncOptions{Rsh: "ssh -p 22 -i key"})
+		assert.Equal(t, "ssh -p 2222 -i key", options.Rsh)
+		options = Endpoint{Host: "host", Port: 2222}.Options(RsyncOptions{Rsh: "ssh -p22"})
+		assert.Equal(t, "ssh -p2222", options.Rsh)
+	})
+}
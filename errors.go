@@ -0,0 +1,91 @@
+package grsync
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// rsync exit codes, see EXIT VALUES in man rsync
+const (
+	exitStartProtocol  = 5
+	exitSocketIO       = 10
+	exitProtocolStream = 12
+	exitTimeout        = 30
+	exitDaemonTimeout  = 35
+	exitRemoteShell    = 255
+)
+
+// RsyncError is returned when rsync exits with non-zero code
+type RsyncError struct {
+	// Code is rsync exit code
+	Code int
+	// Message is the last line rsync wrote to stderr
+	Message string
+
+	err error
+}
+
+func (e *RsyncError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("rsync: exit status %d", e.Code)
+	}
+	return fmt.Sprintf("rsync: exit status %d: %s", e.Code, e.Message)
+}
+
+func (e *RsyncError) Unwrap() error {
+	return e.err
+}
+
+// Retryable reports whether the failure looks transient, e.g. a dropped connection
+func (e *RsyncError) Retryable() bool {
+	switch e.Code {
+	case exitStartProtocol, exitSocketIO, exitProtocolStream, exitTimeout, exitDaemonTimeout, exitRemoteShell:
+		return true
+	}
+	return false
+}
+
+// IsRetryable reports whether err is a transient rsync failure
+func IsRetryable(err error) bool {
+	var hostKeyErr *HostKeyChangedError
+	if errors.As(err, &hostKeyErr) {
+		return false
+	}
+
+	var rsyncErr *RsyncError
+	if errors.As(err, &rsyncErr) {
+		return rsyncErr.Retryable()
+	}
+	return false
+}
+
+// classifyError wraps rsync exit error using its stderr output
+func classifyError(err error, stderr string) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	return exitError(exitErr.ExitCode(), stderr, err)
+}
+
+// exitError returns error of rsync exited with the code
+func exitError(code int, stderr string, err error) error {
+	rsyncErr := &RsyncError{
+		Code:    code,
+		Message: lastLine(stderr),
+		err:     err,
+	}
+	if changed := parseHostKeyChanged(stderr, rsyncErr); changed != nil {
+		return changed
+	}
+
+	return rsyncErr
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
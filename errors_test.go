@@ -0,0 +1,41 @@
+package grsync
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	t.Run("nil error", func(t *testing.T) {
+		assert.NoError(t, classifyError(nil, ""))
+	})
+
+	t.Run("not exit error", func(t *testing.T) {
+		err := errors.New("boom")
+		assert.Equal(t, err, classifyError(err, ""))
+	})
+
+	t.Run("exit error", func(t *testing.T) {
+		exitErr := exec.Command("sh", "-c", "exit 10").Run()
+		err := classifyError(exitErr, "rsync: connection unexpectedly closed\nrsync error: error in socket IO (code 10)\n")
+
+		var rsyncErr *RsyncError
+		assert.True(t, errors.As(err, &rsyncErr))
+		assert.Equal(t, 10, rsyncErr.Code)
+		assert.Equal(t, "rsync error: error in socket IO (code 10)", rsyncErr.Message)
+		assert.True(t, IsRetryable(fmt.Errorf("wrapped: %w", err)))
+
+		var execErr *exec.ExitError
+		assert.True(t, errors.As(err, &execErr))
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		exitErr := exec.Command("sh", "-c", "exit 23").Run()
+		assert.False(t, IsRetryable(classifyError(exitErr, "")))
+		assert.Equal(t, "rsync: exit status 23", classifyError(exitErr, "").Error())
+	})
+}
@@ -0,0 +1,47 @@
+package grsync
+
+import (
+	"time"
+)
+
+// EventType is a type of Event
+type EventType string
+
+const (
+	// EventState is sent when State changes
+	EventState EventType = "state"
+	// EventFile is sent for every itemized change
+	EventFile EventType = "file"
+	// EventResult is sent when rsync process finishes
+	EventResult EventType = "result"
+)
+
+// Event describes progress of the task
+type Event struct {
+	Type EventType
+	Time time.Time
+	// State is set for EventState
+	State State
+	// File is set for EventFile
+	File *FileEvent
+	// Stats, Guarantees and Err are set for EventResult
+	Stats      *Stats
+	Guarantees *Guarantees
+	Err        error
+}
+
+// OnEvent adds listener called for every task event; it's called synchronously, so it should be fast
+func (t *Task) OnEvent(listener func(event Event)) {
+	t.listeners = append(t.listeners, listener)
+}
+
+func (t *Task) emit(event Event) {
+	if len(t.listeners) == 0 {
+		return
+	}
+
+	event.Time = time.Now()
+	for _, listener := range t.listeners {
+		listener(event)
+	}
+}
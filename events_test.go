@@ -0,0 +1,51 @@
+package grsync
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskEvents(t *testing.T) {
+	binary := writeFakeRsync(t, itemizingRsync+`echo "Number of regular files transferred: 3"
+`)
+	destination := t.TempDir()
+
+	var events []Event
+	task := NewTask([]string{"src/"}, destination, RsyncOptions{RsyncBinaryPath: binary, ItemizeChanges: true})
+	task.OnEvent(func(event Event) {
+		assert.False(t, event.Time.IsZero())
+		events = append(events, event)
+	})
+	assert.NoError(t, task.Run())
+
+	counts := map[EventType]int{}
+	for _, event := range events {
+		counts[event.Type]++
+	}
+	assert.Equal(t, 7, counts[EventFile])
+	assert.Equal(t, 1, counts[EventResult])
+	assert.True(t, counts[EventState] > 0)
+
+	for _, event := range events {
+		if event.Type == EventFile {
+			assert.Equal(t, "data/", event.File.Name)
+			assert.Equal(t, filepath.Join(destination, "data"), event.File.Path)
+			break
+		}
+	}
+	last := events[len(events)-1]
+	assert.Equal(t, EventResult, last.Type)
+	assert.Equal(t, 3, last.Stats.FilesTransferred)
+	assert.NoError(t, last.Err)
+
+	var progress []float64
+	for _, event := range events {
+		if event.Type == EventState && event.State.Total > 0 {
+			progress = append(progress, event.State.Progress)
+		}
+	}
+	assert.Equal(t, float64(40), progress[0])
+	assert.Equal(t, float64(60), progress[len(progress)-1])
+}
@@ -0,0 +1,109 @@
+package grsync
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// FakeSuperXattr is the xattr where rsync --fake-super stores privileged attributes
+const FakeSuperXattr = "user.rsync.%stat"
+
+// unix file type and special permission bits as stored by rsync
+const (
+	modeTypeMask   = 0170000
+	modeSocket     = 0140000
+	modeSymlink    = 0120000
+	modeRegular    = 0100000
+	modeBlock      = 0060000
+	modeDir        = 0040000
+	modeChar       = 0020000
+	modeFifo       = 0010000
+	modeSetuid     = 04000
+	modeSetgid     = 02000
+	modeSticky     = 01000
+	modePermission = 0777
+)
+
+// FakeSuperStat is owner, group, mode and device info stored by rsync --fake-super
+type FakeSuperStat struct {
+	// Mode is unix mode including file type bits, e.g. 0100644
+	Mode  uint32 `json:"mode"`
+	Major uint32 `json:"major"`
+	Minor uint32 `json:"minor"`
+	UID   uint32 `json:"uid"`
+	GID   uint32 `json:"gid"`
+}
+
+// FakeSuperEntry is effective metadata of a file in the backup tree
+type FakeSuperEntry struct {
+	// Path is relative to the backup root
+	Path string        `json:"path"`
+	Stat FakeSuperStat `json:"stat"`
+	// Stored is true when Stat is read from the xattr, otherwise it's the file's own metadata
+	Stored  bool      `json:"stored"`
+	ModTime time.Time `json:"mod time"`
+}
+
+// FakeSuperRestoreOptions for RestoreFakeSuper
+type FakeSuperRestoreOptions struct {
+	// KeepXattrs doesn't remove rsync xattrs after restoring metadata
+	KeepXattrs bool
+}
+
+// ParseFakeSuperStat decodes xattr value in rsync format `mode major,minor uid:gid`, mode is octal
+func ParseFakeSuperStat(value string) (FakeSuperStat, error) {
+	var stat FakeSuperStat
+	var rest string
+	n, _ := fmt.Sscanf(value+" end", "%o %d,%d %d:%d %s", &stat.Mode, &stat.Major, &stat.Minor, &stat.UID, &stat.GID, &rest)
+	if n != 6 || rest != "end" {
+		return FakeSuperStat{}, fmt.Errorf("rsync: corrupt %s xattr %q", FakeSuperXattr, value)
+	}
+	return stat, nil
+}
+
+// String encodes stat the same way rsync does
+func (s FakeSuperStat) String() string {
+	return fmt.Sprintf("%o %d,%d %d:%d", s.Mode, s.Major, s.Minor, s.UID, s.GID)
+}
+
+// FileMode converts unix mode to os.FileMode
+func (s FakeSuperStat) FileMode() os.FileMode {
+	mode := os.FileMode(s.Mode & modePermission)
+
+	switch s.Mode & modeTypeMask {
+	case modeSocket:
+		mode |= os.ModeSocket
+	case modeSymlink:
+		mode |= os.ModeSymlink
+	case modeBlock:
+		mode |= os.ModeDevice
+	case modeDir:
+		mode |= os.ModeDir
+	case modeChar:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case modeFifo:
+		mode |= os.ModeNamedPipe
+	}
+
+	if s.Mode&modeSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if s.Mode&modeSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if s.Mode&modeSticky != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+// isSpecial reports whether the file is a device, fifo or socket, stored by rsync as a regular file
+func (s FakeSuperStat) isSpecial() bool {
+	switch s.Mode & modeTypeMask {
+	case modeBlock, modeChar, modeFifo, modeSocket:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ReadFakeSuperStat reads rsync xattr of the file; false is returned if the xattr is not set
+func ReadFakeSuperStat(path string) (FakeSuperStat, bool, error) {
+	buf := make([]byte, 64)
+	n, err := syscall.Getxattr(path, FakeSuperXattr, buf)
+	if errors.Is(err, syscall.ENODATA) || errors.Is(err, syscall.ENOTSUP) {
+		return FakeSuperStat{}, false, nil
+	}
+	if err != nil {
+		return FakeSuperStat{}, false, &os.PathError{Op: "getxattr", Path: path, Err: err}
+	}
+
+	stat, err := ParseFakeSuperStat(string(buf[:n]))
+	return stat, err == nil, err
+}
+
+// WriteFakeSuperStat sets rsync xattr of the file
+func WriteFakeSuperStat(path string, stat FakeSuperStat) error {
+	if err := syscall.Setxattr(path, FakeSuperXattr, []byte(stat.String()), 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: path, Err: err}
+	}
+	return nil
+}
+
+// ListFakeSuper returns effective metadata of every file in the backup tree
+func ListFakeSuper(root string) ([]FakeSuperEntry, error) {
+	var entries []FakeSuperEntry
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entry := FakeSuperEntry{Path: relative, Stat: ownStat(info), ModTime: info.ModTime()}
+
+		// xattr calls follow symlinks and user xattrs can't be set on symlinks anyway
+		if info.Mode()&os.ModeSymlink == 0 {
+			stat, ok, err := ReadFakeSuperStat(path)
+			if err != nil {
+				return err
+			}
+			if ok {
+				entry.Stat, entry.Stored = stat, true
+			}
+		}
+
+		entries = append(entries, entry)
+		return nil
+	})
+
+	return entries, err
+}
+
+// RestoreFakeSuper applies metadata stored in rsync xattrs to the tree; it requires root
+func RestoreFakeSuper(root string, options FakeSuperRestoreOptions) error {
+	entries, err := ListFakeSuper(root)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Stored {
+			if err = restoreEntry(filepath.Join(root, entry.Path), entry, options); err != nil {
+				return err
+			}
+		}
+	}
+
+	// creating devices changes mtime of their directories
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Stat.Mode&modeTypeMask == modeDir {
+			path := filepath.Join(root, entries[i].Path)
+			if err = os.Chtimes(path, entries[i].ModTime, entries[i].ModTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func restoreEntry(path string, entry FakeSuperEntry, options FakeSuperRestoreOptions) error {
+	stat := entry.Stat
+
+	if stat.Mode&modeTypeMask == modeSymlink {
+		return restoreSymlink(path, stat)
+	}
+
+	if stat.isSpecial() {
+		// the special file is replaced, so there is no xattr to remove
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		dev := makeDev(stat.Major, stat.Minor)
+		if err := syscall.Mknod(path, stat.Mode, int(dev)); err != nil {
+			return &os.PathError{Op: "mknod", Path: path, Err: err}
+		}
+	} else if !options.KeepXattrs {
+		if err := syscall.Removexattr(path, FakeSuperXattr); err != nil {
+			return &os.PathError{Op: "removexattr", Path: path, Err: err}
+		}
+	}
+
+	// chown clears setuid and setgid bits, so mode is set after it
+	if err := os.Lchown(path, int(stat.UID), int(stat.GID)); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, stat.FileMode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, time.Now(), entry.ModTime)
+}
+
+// restoreSymlink replaces the regular file holding the link target with the symlink;
+// mode of a symlink is not used and its time can't be set without following it
+func restoreSymlink(path string, stat FakeSuperStat) error {
+	target, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	temp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".symlink")
+	_ = os.Remove(temp)
+	if err = os.Symlink(string(target), temp); err != nil {
+		return err
+	}
+	if err = os.Lchown(temp, int(stat.UID), int(stat.GID)); err != nil {
+		_ = os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// ownStat returns metadata of the file itself
+func ownStat(info os.FileInfo) FakeSuperStat {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FakeSuperStat{}
+	}
+
+	major, minor := splitDev(uint64(sys.Rdev))
+	return FakeSuperStat{
+		Mode:  sys.Mode,
+		Major: major,
+		Minor: minor,
+		UID:   sys.Uid,
+		GID:   sys.Gid,
+	}
+}
+
+// makeDev and splitDev follow glibc encoding of device numbers
+func makeDev(major, minor uint32) uint64 {
+	return uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32
+}
+
+func splitDev(dev uint64) (uint32, uint32) {
+	major := uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+	minor := uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+	return major, minor
+}
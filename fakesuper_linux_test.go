@@ -0,0 +1,85 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeSuperXattrs(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, "etc/shadow", "dev/null", "plain")
+	// rsync stores a symlink as a regular file holding the target
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "etc/link"), []byte("../plain"), 0600))
+	mtime := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(filepath.Join(root, "dev"), mtime, mtime))
+
+	shadow := FakeSuperStat{Mode: 0100640, UID: 0, GID: 42}
+	err := WriteFakeSuperStat(filepath.Join(root, "etc/shadow"), shadow)
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("user xattrs are not supported")
+	}
+	assert.NoError(t, err)
+	device := FakeSuperStat{Mode: 020666, Major: 1, Minor: 3}
+	assert.NoError(t, WriteFakeSuperStat(filepath.Join(root, "dev/null"), device))
+	link := FakeSuperStat{Mode: 0120777, UID: 0, GID: 42}
+	assert.NoError(t, WriteFakeSuperStat(filepath.Join(root, "etc/link"), link))
+
+	entries, err := ListFakeSuper(root)
+	assert.NoError(t, err)
+	byPath := map[string]FakeSuperEntry{}
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+	}
+	assert.True(t, byPath["etc/shadow"].Stored)
+	assert.Equal(t, shadow, byPath["etc/shadow"].Stat)
+	assert.Equal(t, device, byPath["dev/null"].Stat)
+	assert.False(t, byPath["plain"].Stored)
+	assert.Equal(t, uint32(0100644), byPath["plain"].Stat.Mode)
+	assert.Equal(t, uint32(os.Getuid()), byPath["plain"].Stat.UID)
+
+	if os.Getuid() != 0 {
+		t.Skip("restore requires root")
+	}
+	assert.NoError(t, RestoreFakeSuper(root, FakeSuperRestoreOptions{}))
+
+	info, err := os.Stat(filepath.Join(root, "etc/shadow"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode())
+	assert.Equal(t, uint32(42), info.Sys().(*syscall.Stat_t).Gid)
+	_, ok, err := ReadFakeSuperStat(filepath.Join(root, "etc/shadow"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	info, err = os.Stat(filepath.Join(root, "dev/null"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeDevice|os.ModeCharDevice|0666, info.Mode())
+	major, minor := splitDev(uint64(info.Sys().(*syscall.Stat_t).Rdev))
+	assert.Equal(t, []uint32{1, 3}, []uint32{major, minor})
+
+	info, err = os.Lstat(filepath.Join(root, "etc/link"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSymlink, info.Mode().Type())
+	assert.Equal(t, uint32(42), info.Sys().(*syscall.Stat_t).Gid)
+	target, err := os.Readlink(filepath.Join(root, "etc/link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "../plain", target)
+	info, err = os.Stat(filepath.Join(root, "plain"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode())
+
+	info, err = os.Stat(filepath.Join(root, "dev"))
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(mtime))
+}
+
+func TestDevNumbers(t *testing.T) {
+	major, minor := splitDev(makeDev(259, 65536))
+	assert.Equal(t, uint32(259), major)
+	assert.Equal(t, uint32(65536), minor)
+}
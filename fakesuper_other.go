@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package grsync
+
+import (
+	"errors"
+)
+
+var errFakeSuperUnsupported = errors.New("rsync: fake-super xattrs are supported only on linux")
+
+// ReadFakeSuperStat reads rsync xattr of the file; false is returned if the xattr is not set
+func ReadFakeSuperStat(path string) (FakeSuperStat, bool, error) {
+	return FakeSuperStat{}, false, errFakeSuperUnsupported
+}
+
+// WriteFakeSuperStat sets rsync xattr of the file
+func WriteFakeSuperStat(path string, stat FakeSuperStat) error {
+	return errFakeSuperUnsupported
+}
+
+// ListFakeSuper returns effective metadata of every file in the backup tree
+func ListFakeSuper(root string) ([]FakeSuperEntry, error) {
+	return nil, errFakeSuperUnsupported
+}
+
+// RestoreFakeSuper applies metadata stored in rsync xattrs to the tree; it requires root
+func RestoreFakeSuper(root string, options FakeSuperRestoreOptions) error {
+	return errFakeSuperUnsupported
+}
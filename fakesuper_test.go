@@ -0,0 +1,25 @@
+package grsync
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFakeSuperStat(t *testing.T) {
+	stat, err := ParseFakeSuperStat("20660 8,1 0:6")
+	assert.NoError(t, err)
+	assert.Equal(t, FakeSuperStat{Mode: 020660, Major: 8, Minor: 1, UID: 0, GID: 6}, stat)
+	assert.Equal(t, "20660 8,1 0:6", stat.String())
+	assert.Equal(t, os.ModeDevice|os.ModeCharDevice|0660, stat.FileMode())
+
+	stat, err = ParseFakeSuperStat("104755 0,0 1000:1000")
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSetuid|0755, stat.FileMode())
+
+	for _, value := range []string{"", "100644", "100644 0,0 0", "abc 0,0 0:0", "100644 0,0 0:0 extra"} {
+		_, err = ParseFakeSuperStat(value)
+		assert.Error(t, err, value)
+	}
+}
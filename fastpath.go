@@ -0,0 +1,179 @@
+package grsync
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FastPathStats counts files written by the FastPath middleware
+type FastPathStats struct {
+	// Cloned files share data with the source by reflink
+	Cloned int `json:"cloned"`
+	// Copied files are copied by the kernel, with copy_file_range where it's available
+	Copied int `json:"copied"`
+	// Bytes is a size of cloned and copied files
+	Bytes int64 `json:"bytes"`
+}
+
+// FastPathStats returns files written by the FastPath middleware in the last run
+func (t *Task) FastPathStats() FastPathStats {
+	return t.fastPath
+}
+
+// FastPath returns middleware for local-to-local syncs of a directory: new and changed files listed
+// by a dry run are cloned with FICLONE when the filesystem supports reflinks, or copied by the kernel
+// otherwise, with modification time of the source. Then rsync runs as usual: it skips the data of
+// these files, applies metadata according to RsyncOptions and does everything else.
+// Remote endpoints, endpoints on different filesystems, several sources, backups, RunAs and in-place
+// or forced updates fall back to rsync alone.
+func FastPath() Middleware {
+	return func(next Runner) Runner {
+		return RunnerFunc(func(task *Task) error {
+			task.fastPath = FastPathStats{}
+			source, ok := fastPathSource(task)
+			if !ok {
+				return next.Run(task)
+			}
+
+			// rsync reports problems of the listing itself in the real run
+			changes, err := dryRun(task.rsync.Source, task.rsync.Destination, task.options)
+			if err != nil {
+				return next.Run(task)
+			}
+
+			fsync := task.options.Durability.apply(task.options).Fsync
+			for _, change := range changes {
+				flags := change.Change.Flags
+				if flags[0] != '>' || change.Change.FileType() != 'f' || change.Path == "" {
+					continue
+				}
+
+				cloned, size, err := fastCopy(filepath.Join(source, change.Name), change.Path, fsync)
+				if err != nil {
+					// rsync copies the rest
+					break
+				}
+				if cloned {
+					task.fastPath.Cloned++
+				} else {
+					task.fastPath.Copied++
+				}
+				task.fastPath.Bytes += size
+			}
+
+			return next.Run(task)
+		})
+	}
+}
+
+// fastPathSource returns directory the names printed by rsync are relative to
+func fastPathSource(task *Task) (string, bool) {
+	options := task.options
+	if len(task.rsync.Source) != 1 || options.DryRun || options.Backup || options.BackupDir != "" ||
+		options.Append || options.AppendVerify || options.Inplace || options.IgnoreTimes || options.RunAs != nil {
+		return "", false
+	}
+
+	source, destination := task.rsync.Source[0], task.rsync.Destination
+	if destination == "" || isRemotePath(source) || isRemotePath(destination) {
+		return "", false
+	}
+	info, err := os.Stat(source)
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+	// files are cloned or copied by the kernel only within one filesystem
+	parent := existingParent(destination)
+	if parent == nil {
+		return "", false
+	}
+	sourceInode, destinationInode := inodeOf(info), inodeOf(parent)
+	if !sourceInode.valid || !destinationInode.valid || sourceInode.dev != destinationInode.dev {
+		return "", false
+	}
+
+	if strings.HasSuffix(source, "/") {
+		return source, true
+	}
+	// without the trailing slash the names start with the directory itself
+	return filepath.Dir(source), true
+}
+
+// existingParent returns info of the path or of its closest existing parent; nil if none is found
+func existingParent(path string) os.FileInfo {
+	path = filepath.Clean(path)
+	for {
+		if info, err := os.Stat(path); err == nil {
+			return info
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return nil
+		}
+		path = parent
+	}
+}
+
+// fastCopy replaces target with a reflink or kernel copy of source; true is returned for a reflink
+func fastCopy(source, target string, fsync bool) (bool, int64, error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return false, 0, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	info, err := in.Stat()
+	if err != nil {
+		return false, 0, err
+	}
+
+	dir := filepath.Dir(target)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return false, 0, err
+	}
+	// permissions are rsync's business: like rsync without Perms, a new file gets permissions of
+	// the source masked by umask and an existing one keeps its own; with Perms rsync sets them later
+	perm := info.Mode().Perm()
+	existing, err := os.Stat(target)
+	if err == nil {
+		perm = existing.Mode().Perm()
+	}
+	temp := filepath.Join(dir, "."+filepath.Base(target)+".fastpath")
+	_ = os.Remove(temp)
+	out, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return false, 0, err
+	}
+
+	cloned := cloneFile(out, in) == nil
+	if !cloned {
+		// os.File uses copy_file_range for it on linux
+		_, err = io.Copy(out, in)
+	}
+	if err == nil && fsync {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && existing != nil {
+		// umask applies to the new file
+		err = os.Chmod(temp, perm)
+	}
+	if err == nil {
+		// rsync's quick check skips the data of the file with the same size and mtime
+		err = os.Chtimes(temp, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(temp, target)
+	}
+	if err != nil {
+		_ = os.Remove(temp)
+		return false, 0, err
+	}
+
+	return cloned, info.Size(), nil
+}
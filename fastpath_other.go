@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package grsync
+
+import (
+	"errors"
+	"os"
+)
+
+var errCloneUnsupported = errors.New("rsync: reflinks are supported only on linux")
+
+// cloneFile makes out share data of in; it fails if the filesystem doesn't support reflinks
+// or files are on different filesystems
+func cloneFile(out, in *os.File) error {
+	return errCloneUnsupported
+}
@@ -0,0 +1,138 @@
+package grsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dryRunChangesRsync = `for arg; do echo "$arg"; done >> "$(dirname "$0")/calls"
+echo "---" >> "$(dirname "$0")/calls"
+for arg; do
+	if [ "$arg" = "--dry-run" ]; then
+		cat "$(dirname "$0")/changes"
+	fi
+done`
+
+func TestFastPath(t *testing.T) {
+	binary := writeFakeRsync(t, dryRunChangesRsync)
+	setChanges := func(changes string) {
+		require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(binary), "changes"), []byte(changes), 0600))
+	}
+
+	source := filepath.Join(t.TempDir(), "src")
+	writeTree(t, source, "dir/a.txt", "b.txt", "c.txt")
+	mtime := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(source, "dir/a.txt"), mtime, mtime))
+
+	assertCopied := func(t *testing.T, destination, name string) {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(destination, name))
+		require.NoError(t, err)
+		assert.Equal(t, name, string(data))
+
+		sourceInfo, err := os.Stat(filepath.Join(source, name))
+		require.NoError(t, err)
+		info, err := os.Stat(filepath.Join(destination, name))
+		require.NoError(t, err)
+		assert.Equal(t, sourceInfo.ModTime(), info.ModTime())
+	}
+
+	t.Run("changed files", func(t *testing.T) {
+		setChanges("cd+++++++++ dir/\n>f+++++++++ dir/a.txt\n>f.st...... b.txt\n.f...p..... c.txt\n")
+		destination := filepath.Join(t.TempDir(), "dst")
+		task := NewTask([]string{source + "/"}, destination, RsyncOptions{RsyncBinaryPath: binary})
+		task.Use(FastPath())
+		require.NoError(t, task.Run())
+
+		assertCopied(t, destination, "dir/a.txt")
+		assertCopied(t, destination, "b.txt")
+		assert.NoFileExists(t, filepath.Join(destination, "c.txt"))
+		stats := task.FastPathStats()
+		assert.Equal(t, 2, stats.Cloned+stats.Copied)
+		assert.Equal(t, int64(len("dir/a.txt")+len("b.txt")), stats.Bytes)
+
+		calls := readCalls(t, binary)
+		assert.Contains(t, calls[len(calls)-2], "--dry-run\n")
+		assert.NotContains(t, calls[len(calls)-1], "--dry-run\n")
+	})
+
+	t.Run("permissions", func(t *testing.T) {
+		setChanges(">f+++++++++ dir/a.txt\n>f.st...... b.txt\n")
+		destination := t.TempDir()
+		writeTree(t, destination, "b.txt")
+		require.NoError(t, os.Chmod(filepath.Join(destination, "b.txt"), 0600))
+		require.NoError(t, os.Chmod(filepath.Join(source, "dir/a.txt"), 0640))
+		task := NewTaskWithoutForceOptions([]string{source + "/"}, destination, RsyncOptions{RsyncBinaryPath: binary})
+		task.Use(FastPath())
+		require.NoError(t, task.Run())
+		stats := task.FastPathStats()
+		assert.Equal(t, 2, stats.Cloned+stats.Copied)
+
+		// without Perms the existing file keeps its permissions and the new one gets the source's
+		info, err := os.Stat(filepath.Join(destination, "b.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		info, err = os.Stat(filepath.Join(destination, "dir/a.txt"))
+		require.NoError(t, err)
+		assert.Zero(t, info.Mode().Perm()&^0640)
+	})
+
+	t.Run("directory itself", func(t *testing.T) {
+		setChanges(">f+++++++++ src/b.txt\n")
+		destination := t.TempDir()
+		task := NewTask([]string{source}, destination, RsyncOptions{RsyncBinaryPath: binary})
+		task.Use(FastPath())
+		require.NoError(t, task.Run())
+
+		data, err := os.ReadFile(filepath.Join(destination, "src/b.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "b.txt", string(data))
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		setChanges(">f+++++++++ b.txt\n")
+		before := len(readCalls(t, binary))
+		for _, options := range []RsyncOptions{
+			{RsyncBinaryPath: binary, Backup: true},
+			{RsyncBinaryPath: binary, DryRun: true},
+			{RsyncBinaryPath: binary, Inplace: true},
+			{RsyncBinaryPath: binary, IgnoreTimes: true},
+		} {
+			task := NewTask([]string{source + "/"}, t.TempDir(), options)
+			task.Use(FastPath())
+			require.NoError(t, task.Run())
+			assert.Equal(t, FastPathStats{}, task.FastPathStats())
+		}
+
+		task := NewTask([]string{source + "/"}, "host:/backup", RsyncOptions{RsyncBinaryPath: binary})
+		task.Use(FastPath())
+		require.NoError(t, task.Run())
+		assert.Len(t, readCalls(t, binary), before+5)
+		assert.NoDirExists(t, "host:")
+
+		_, ok := fastPathSource(NewTask([]string{source + "/"}, t.TempDir(), RsyncOptions{RunAs: &RunAs{UID: 1000}}))
+		assert.False(t, ok)
+
+		other, err := os.MkdirTemp("/dev/shm", "fastpath-")
+		if err != nil {
+			t.Skip("no other filesystem to test")
+		}
+		defer func() {
+			_ = os.RemoveAll(other)
+		}()
+		sourceInfo, err := os.Stat(source)
+		require.NoError(t, err)
+		otherInfo, err := os.Stat(other)
+		require.NoError(t, err)
+		if inodeOf(sourceInfo).dev == inodeOf(otherInfo).dev {
+			t.Skip("no other filesystem to test")
+		}
+		_, ok = fastPathSource(NewTask([]string{source + "/"}, filepath.Join(other, "dst"), RsyncOptions{}))
+		assert.False(t, ok)
+	})
+}
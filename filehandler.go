@@ -0,0 +1,182 @@
+package grsync
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const deletingFlags = "*deleting"
+
+// itemizeMatcher extracts flags and name from --itemize-changes output, e.g. `>f+++++++++ dir/file`
+var itemizeMatcher = regexp.MustCompile(`^([<>ch.][fdLDS][cstpoguaxnb.+? ]{7,9}|\*deleting) +(\S.*)$`)
+
+// ItemizedChange is a change-summary printed by rsync with ItemizeChanges option
+type ItemizedChange struct {
+	// Flags are raw flags, e.g. `>f.st......` or `*deleting`
+	Flags string `json:"flags"`
+}
+
+// Transferred reports whether file data was sent or received
+func (c ItemizedChange) Transferred() bool {
+	return len(c.Flags) > 0 && (c.Flags[0] == '<' || c.Flags[0] == '>')
+}
+
+// Deleted reports whether the file was deleted on the receiver
+func (c ItemizedChange) Deleted() bool {
+	return c.Flags == deletingFlags
+}
+
+// Created reports whether the file is new on the receiver
+func (c ItemizedChange) Created() bool {
+	return len(c.Flags) > 2 && strings.Trim(c.Flags[2:], "+") == ""
+}
+
+// FileType returns `f` for a file, `d` for a directory, `L` for a symlink, `D` for a device and `S` for a special file
+func (c ItemizedChange) FileType() byte {
+	if len(c.Flags) < 2 || c.Deleted() {
+		return 0
+	}
+	return c.Flags[1]
+}
+
+// FileEvent is a file change parsed from rsync output
+type FileEvent struct {
+	// Name is a path relative to the destination as printed by rsync
+	Name string `json:"name"`
+	// Path is a path in the local destination; empty for remote destinations
+	Path   string         `json:"path"`
+	Change ItemizedChange `json:"change"`
+}
+
+// FileHandler is called for each file as soon as rsync is done with it
+type FileHandler func(event FileEvent) error
+
+// FileHandlerError contains errors returned by FileHandler
+type FileHandlerError struct {
+	Errors []error
+}
+
+func (e *FileHandlerError) Error() string {
+	return fmt.Sprintf("rsync: %d file handlers failed, first: %s", len(e.Errors), e.Errors[0])
+}
+
+// SetFileHandler calls handler for every itemized change in a pool of workers; rsync should be started
+// with ItemizeChanges option. Run waits for all handlers before returning.
+func (t *Task) SetFileHandler(handler FileHandler, workers int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	t.files = &filePool{
+		handler:     handler,
+		workers:     workers,
+		destination: t.rsync.Destination,
+	}
+}
+
+type filePool struct {
+	handler     FileHandler
+	workers     int
+	destination string
+
+	events  chan FileEvent
+	wg      sync.WaitGroup
+	pending *FileEvent
+
+	mu     sync.Mutex
+	errors []error
+}
+
+func (p *filePool) start() {
+	p.events = make(chan FileEvent)
+	p.pending = nil
+	p.errors = nil
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for event := range p.events {
+				if err := p.handler(event); err != nil {
+					p.mu.Lock()
+					p.errors = append(p.errors, fmt.Errorf("%s: %w", event.Name, err))
+					p.mu.Unlock()
+				}
+			}
+		}()
+	}
+}
+
+// line handles a stdout line; a transferred file is dispatched when its transfer is finished,
+// i.e. on the final progress line or the next itemized line
+func (p *filePool) line(line string) {
+	if strings.Contains(line, "xfr#") {
+		p.flush()
+		return
+	}
+
+	event, ok := parseItemized(line, p.destination)
+	if !ok {
+		return
+	}
+
+	p.flush()
+	if event.Change.Transferred() {
+		p.pending = &event
+		return
+	}
+	p.events <- event
+}
+
+func (p *filePool) flush() {
+	if p.pending != nil {
+		p.events <- *p.pending
+		p.pending = nil
+	}
+}
+
+// wait dispatches the last file and waits for all handlers
+func (p *filePool) wait() error {
+	p.flush()
+	close(p.events)
+	p.wg.Wait()
+
+	if len(p.errors) == 0 {
+		return nil
+	}
+	return &FileHandlerError{Errors: p.errors}
+}
+
+func parseItemized(line, destination string) (FileEvent, bool) {
+	matches := itemizeMatcher.FindStringSubmatch(line)
+	if matches == nil {
+		return FileEvent{}, false
+	}
+
+	event := FileEvent{
+		Name:   matches[2],
+		Change: ItemizedChange{Flags: strings.TrimSpace(matches[1])},
+	}
+	switch {
+	case event.Change.FileType() == 'L':
+		event.Name = strings.SplitN(event.Name, " -> ", 2)[0]
+	case event.Change.Flags[0] == 'h':
+		event.Name = strings.SplitN(event.Name, " => ", 2)[0]
+	}
+	if destination != "" && !isRemotePath(destination) {
+		event.Path = filepath.Join(destination, event.Name)
+	}
+
+	return event, true
+}
+
+// isRemotePath reports whether rsync treats path as remote, e.g. `host:/path` or `rsync://host/module`
+func isRemotePath(path string) bool {
+	if strings.HasPrefix(path, "rsync://") {
+		return true
+	}
+	colon := strings.IndexByte(path, ':')
+	slash := strings.IndexByte(path, '/')
+	return colon >= 0 && (slash < 0 || colon < slash)
+}
@@ -0,0 +1,106 @@
+package grsync
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const itemizingRsync = `cat <<'OUT'
+sending incremental file list
+cd+++++++++ data/
+>f+++++++++ data/a.jpg
+     32.77K  50%    0.00kB/s    0:00:00
+     65.54K 100%   31.25MB/s    0:00:00 (xfr#1, to-chk=3/5)
+>f.st...... data/b.jpg
+     65.54K 100%   31.25MB/s    0:00:00 (xfr#2, to-chk=2/5)
+.f....og... data/owner
+cL+++++++++ data/link -> a.jpg
+*deleting   data/old.jpg
+>f+++++++++ data/c.jpg
+OUT
+`
+
+func TestParseItemized(t *testing.T) {
+	event, ok := parseItemized(">f.st...... dir/file name", "/backup")
+	assert.True(t, ok)
+	assert.Equal(t, "dir/file name", event.Name)
+	assert.Equal(t, "/backup/dir/file name", event.Path)
+	assert.True(t, event.Change.Transferred())
+	assert.False(t, event.Change.Created())
+	assert.Equal(t, byte('f'), event.Change.FileType())
+
+	event, ok = parseItemized("cL+++++++++ link -> target", "host:/backup")
+	assert.True(t, ok)
+	assert.Equal(t, "link", event.Name)
+	assert.Empty(t, event.Path)
+	assert.True(t, event.Change.Created())
+
+	event, ok = parseItemized("hf+++++++++ b => a", "")
+	assert.True(t, ok)
+	assert.Equal(t, "b", event.Name)
+
+	event, ok = parseItemized("*deleting   old/file", "")
+	assert.True(t, ok)
+	assert.True(t, event.Change.Deleted())
+	assert.Equal(t, byte(0), event.Change.FileType())
+
+	for _, line := range []string{"sending incremental file list", "     65.54K 100%   31.25MB/s    0:00:00", "file.txt", ""} {
+		_, ok = parseItemized(line, "")
+		assert.False(t, ok, line)
+	}
+}
+
+func TestIsRemotePath(t *testing.T) {
+	assert.True(t, isRemotePath("host:/path"))
+	assert.True(t, isRemotePath("user@host:path"))
+	assert.True(t, isRemotePath("rsync://host/module"))
+	assert.False(t, isRemotePath("/local/dir:with:colons"))
+	assert.False(t, isRemotePath("./a:b"))
+}
+
+func TestTaskFileHandler(t *testing.T) {
+	binary := writeFakeRsync(t, itemizingRsync)
+	destination := t.TempDir()
+
+	t.Run("handles all files before returning", func(t *testing.T) {
+		var mu sync.Mutex
+		var names []string
+		task := NewTask([]string{"src/"}, destination, RsyncOptions{RsyncBinaryPath: binary, ItemizeChanges: true})
+		task.SetFileHandler(func(event FileEvent) error {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			names = append(names, event.Name)
+			if event.Name == "data/a.jpg" {
+				assert.Equal(t, filepath.Join(destination, "data/a.jpg"), event.Path)
+			}
+			return nil
+		}, 3)
+
+		assert.NoError(t, task.Run())
+		sort.Strings(names)
+		assert.Equal(t, []string{"data/", "data/a.jpg", "data/b.jpg", "data/c.jpg", "data/link", "data/old.jpg", "data/owner"}, names)
+		assert.Equal(t, "data/c.jpg", task.State().CopiedObject)
+	})
+
+	t.Run("collects errors", func(t *testing.T) {
+		task := NewTask([]string{"src/"}, destination, RsyncOptions{RsyncBinaryPath: binary, ItemizeChanges: true})
+		task.SetFileHandler(func(event FileEvent) error {
+			if event.Change.Transferred() {
+				return errors.New("index failed")
+			}
+			return nil
+		}, 2)
+
+		err := task.Run()
+		var handlerErr *FileHandlerError
+		assert.True(t, errors.As(err, &handlerErr))
+		assert.Len(t, handlerErr.Errors, 3)
+	})
+}
@@ -0,0 +1,327 @@
+package grsync
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HostKeyChangedError is returned when the host key doesn't match the known or pinned one
+type HostKeyChangedError struct {
+	// Host is a name as it appears in known_hosts, e.g. `[host]:2222`
+	Host string
+	// Fingerprint is the SHA256 fingerprint of the key sent by the host, if known
+	Fingerprint string
+
+	err error
+}
+
+func (e *HostKeyChangedError) Error() string {
+	if e.Fingerprint == "" {
+		return fmt.Sprintf("rsync: host key for %s has changed", e.Host)
+	}
+	return fmt.Sprintf("rsync: host key for %s has changed, got %s", e.Host, e.Fingerprint)
+}
+
+func (e *HostKeyChangedError) Unwrap() error {
+	return e.err
+}
+
+// HostKeyStore keeps trusted host keys in a dedicated known_hosts file.
+// Unknown hosts are trusted on first use unless their fingerprints are pinned.
+type HostKeyStore struct {
+	// Scan fetches known_hosts lines of a host with pinned fingerprints seen the first time; by default ssh-keyscan
+	Scan func(host string, port int) ([]string, error)
+
+	path string
+
+	mu   sync.Mutex
+	pins map[string][]string
+}
+
+// NewHostKeyStore returns store backed by known_hosts file at path
+func NewHostKeyStore(path string) *HostKeyStore {
+	return &HostKeyStore{
+		Scan: scanHostKeys,
+		path: path,
+		pins: map[string][]string{},
+	}
+}
+
+// Pin accepts only keys with given SHA256 fingerprints for the endpoint
+func (s *HostKeyStore) Pin(endpoint Endpoint, fingerprints ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pins[knownHostName(endpoint)] = fingerprints
+}
+
+// Rotate forgets stored keys of the endpoint and pins new fingerprints;
+// without fingerprints the next key is trusted on first use
+func (s *HostKeyStore) Rotate(endpoint Endpoint, fingerprints ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host := knownHostName(endpoint)
+	if len(fingerprints) == 0 {
+		delete(s.pins, host)
+	} else {
+		s.pins[host] = fingerprints
+	}
+
+	return s.rewrite(func(line knownHostLine) bool {
+		return !line.matches(host)
+	})
+}
+
+// Fingerprints returns SHA256 fingerprints of stored keys of the endpoint
+func (s *HostKeyStore) Fingerprints(endpoint Endpoint) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines, err := s.lines()
+	if err != nil {
+		return nil, err
+	}
+
+	var fingerprints []string
+	for _, line := range lines {
+		if line.matches(knownHostName(endpoint)) {
+			fingerprints = append(fingerprints, line.fingerprint())
+		}
+	}
+	return fingerprints, nil
+}
+
+// SSHOptions returns ssh options which enforce the store policy for the endpoint
+func (s *HostKeyStore) SSHOptions(endpoint Endpoint) (SSHOptions, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	options := SSHOptions{
+		Port: endpoint.Port,
+		Options: []string{
+			"UserKnownHostsFile=" + s.path,
+			"GlobalKnownHostsFile=/dev/null",
+			"HashKnownHosts=no",
+		},
+	}
+
+	host := knownHostName(endpoint)
+	pins, pinned := s.pins[host]
+	if !pinned {
+		return options.With("StrictHostKeyChecking=accept-new"), nil
+	}
+
+	if err := s.verifyPinned(endpoint, host, pins); err != nil {
+		return SSHOptions{}, err
+	}
+	return options.With("StrictHostKeyChecking=yes"), nil
+}
+
+// verifyPinned checks stored keys of the host against pins, fetching them if the host is unknown
+func (s *HostKeyStore) verifyPinned(endpoint Endpoint, host string, pins []string) error {
+	lines, err := s.lines()
+	if err != nil {
+		return err
+	}
+
+	var known []knownHostLine
+	for _, line := range lines {
+		if line.matches(host) {
+			known = append(known, line)
+		}
+	}
+	for _, line := range known {
+		if !containsString(pins, line.fingerprint()) {
+			return &HostKeyChangedError{Host: host, Fingerprint: line.fingerprint()}
+		}
+	}
+	if len(known) > 0 {
+		return nil
+	}
+
+	scanned, err := s.Scan(endpoint.Host, endpoint.Port)
+	if err != nil {
+		return err
+	}
+
+	var trusted []string
+	var fingerprint string
+	for _, raw := range scanned {
+		line, ok := parseKnownHostLine(raw)
+		if !ok {
+			continue
+		}
+		fingerprint = line.fingerprint()
+		if containsString(pins, fingerprint) {
+			trusted = append(trusted, host+" "+line.keyType+" "+line.key)
+		}
+	}
+	if len(trusted) == 0 {
+		return &HostKeyChangedError{Host: host, Fingerprint: fingerprint}
+	}
+
+	return s.append(trusted)
+}
+
+type knownHostLine struct {
+	raw     string
+	hosts   []string
+	keyType string
+	key     string
+}
+
+func parseKnownHostLine(raw string) (knownHostLine, bool) {
+	fields := strings.Fields(raw)
+	if len(fields) < 3 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "@") {
+		return knownHostLine{raw: raw}, false
+	}
+
+	return knownHostLine{
+		raw:     raw,
+		hosts:   strings.Split(fields[0], ","),
+		keyType: fields[1],
+		key:     fields[2],
+	}, true
+}
+
+func (l knownHostLine) matches(host string) bool {
+	return containsString(l.hosts, host)
+}
+
+// fingerprint returns key fingerprint in the same format as ssh-keygen -l
+func (l knownHostLine) fingerprint() string {
+	blob, err := base64.StdEncoding.DecodeString(l.key)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func (s *HostKeyStore) lines() ([]knownHostLine, error) {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var lines []knownHostLine
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// comments and marker lines don't match any host, rewrite copies them as they are
+		line, _ := parseKnownHostLine(scanner.Text())
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func (s *HostKeyStore) append(lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rewrite keeps only lines accepted by keep, unparsed lines like comments or `@revoked` are always kept
+func (s *HostKeyStore) rewrite(keep func(line knownHostLine) bool) error {
+	lines, err := s.lines()
+	if err != nil || lines == nil {
+		return err
+	}
+
+	var kept strings.Builder
+	for _, line := range lines {
+		if line.hosts == nil || keep(line) {
+			kept.WriteString(line.raw + "\n")
+		}
+	}
+
+	temp := s.path + ".tmp"
+	if err = os.WriteFile(temp, []byte(kept.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, s.path)
+}
+
+// knownHostName returns host name in known_hosts notation
+func knownHostName(endpoint Endpoint) string {
+	if endpoint.Port <= 0 || endpoint.Port == 22 {
+		return endpoint.Host
+	}
+	return "[" + endpoint.Host + "]:" + strconv.Itoa(endpoint.Port)
+}
+
+func scanHostKeys(host string, port int) ([]string, error) {
+	arguments := []string{"-T", "10"}
+	if port > 0 {
+		arguments = append(arguments, "-p", strconv.Itoa(port))
+	}
+
+	output, err := exec.Command("ssh-keyscan", append(arguments, host)...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+}
+
+// parseHostKeyChanged detects ssh host key mismatch message in stderr
+func parseHostKeyChanged(stderr string, err error) *HostKeyChangedError {
+	const (
+		changedMarker     = "REMOTE HOST IDENTIFICATION HAS CHANGED"
+		fingerprintMarker = "sent by the remote host is"
+		hostPrefix        = "Host key for "
+		hostSuffix        = " has changed"
+	)
+
+	if !strings.Contains(stderr, changedMarker) {
+		return nil
+	}
+
+	changed := &HostKeyChangedError{err: err}
+	lines := strings.Split(stderr, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, fingerprintMarker) && i+1 < len(lines) {
+			changed.Fingerprint = strings.TrimSuffix(strings.TrimSpace(lines[i+1]), ".")
+		}
+		if strings.HasPrefix(line, hostPrefix) {
+			if end := strings.Index(line, hostSuffix); end > len(hostPrefix) {
+				changed.Host = line[len(hostPrefix):end]
+			}
+		}
+	}
+
+	return changed
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
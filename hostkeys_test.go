@@ -0,0 +1,148 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// generateHostKey returns known_hosts line and fingerprint reported by ssh-keygen
+func generateHostKey(t *testing.T, host string) (string, string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is required")
+	}
+	key := filepath.Join(t.TempDir(), "key")
+	if err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).Run(); err != nil {
+		t.Fatal(err)
+	}
+	public, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("ssh-keygen", "-l", "-f", key+".pub").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := strings.Fields(string(public))
+	return host + " " + fields[0] + " " + fields[1], strings.Fields(string(output))[1]
+}
+
+func TestHostKeyStore(t *testing.T) {
+	endpoint := Endpoint{Host: "backup.local", Port: 2222}
+	line, fingerprint := generateHostKey(t, "[backup.local]:2222")
+	_, otherFingerprint := generateHostKey(t, "[backup.local]:2222")
+
+	newStore := func(t *testing.T) *HostKeyStore {
+		store := NewHostKeyStore(filepath.Join(t.TempDir(), "known_hosts"))
+		store.Scan = func(host string, port int) ([]string, error) {
+			assert.Equal(t, "backup.local", host)
+			assert.Equal(t, 2222, port)
+			return []string{"# comment", line}, nil
+		}
+		return store
+	}
+
+	t.Run("trust on first use", func(t *testing.T) {
+		store := newStore(t)
+		options, err := store.SSHOptions(endpoint)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2222, options.Port)
+		assert.Contains(t, options.Options, "StrictHostKeyChecking=accept-new")
+		assert.Contains(t, options.Options, "UserKnownHostsFile="+store.path)
+	})
+
+	t.Run("pinned host is scanned", func(t *testing.T) {
+		store := newStore(t)
+		store.Pin(endpoint, fingerprint)
+		options, err := store.SSHOptions(endpoint)
+
+		assert.NoError(t, err)
+		assert.Contains(t, options.Options, "StrictHostKeyChecking=yes")
+		fingerprints, err := store.Fingerprints(endpoint)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{fingerprint}, fingerprints)
+	})
+
+	t.Run("pinned mismatch", func(t *testing.T) {
+		store := newStore(t)
+		store.Pin(endpoint, otherFingerprint)
+		_, err := store.SSHOptions(endpoint)
+
+		var changed *HostKeyChangedError
+		assert.True(t, errors.As(err, &changed))
+		assert.Equal(t, "[backup.local]:2222", changed.Host)
+		assert.Equal(t, fingerprint, changed.Fingerprint)
+	})
+
+	t.Run("stored key doesn't match new pin", func(t *testing.T) {
+		store := newStore(t)
+		store.Pin(endpoint, fingerprint)
+		_, err := store.SSHOptions(endpoint)
+		assert.NoError(t, err)
+
+		store.Pin(endpoint, otherFingerprint)
+		_, err = store.SSHOptions(endpoint)
+		var changed *HostKeyChangedError
+		assert.True(t, errors.As(err, &changed))
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		store := newStore(t)
+		store.Pin(endpoint, otherFingerprint)
+		kept := "# comment\nother.local ssh-ed25519 AAAA\n@revoked [backup.local]:2222 ssh-ed25519 BBBB\n"
+		assert.NoError(t, os.WriteFile(store.path, []byte(line+"\n"+kept), 0600))
+
+		assert.NoError(t, store.Rotate(endpoint))
+		fingerprints, err := store.Fingerprints(endpoint)
+		assert.NoError(t, err)
+		assert.Empty(t, fingerprints)
+
+		options, err := store.SSHOptions(endpoint)
+		assert.NoError(t, err)
+		assert.Contains(t, options.Options, "StrictHostKeyChecking=accept-new")
+
+		data, err := os.ReadFile(store.path)
+		assert.NoError(t, err)
+		assert.Equal(t, kept, string(data))
+	})
+}
+
+func TestParseHostKeyChanged(t *testing.T) {
+	const stderr = `@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @
+@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!
+Someone could be eavesdropping on you right now (man-in-the-middle attack)!
+It is also possible that a host key has just been changed.
+The fingerprint for the ED25519 key sent by the remote host is
+SHA256:2Xb0Yq8ZLpJw6lP1TfHqgk2eN8eM9bnkGXoG8dA3y2c.
+Please contact your system administrator.
+Add correct host key in /root/.ssh/known_hosts to get rid of this message.
+Offending ED25519 key in /root/.ssh/known_hosts:3
+Host key for backup.local has changed and you have requested strict checking.
+Host key verification failed.
+rsync: connection unexpectedly closed (0 bytes received so far) [sender]
+rsync error: unexplained error (code 255) at io.c(231) [sender=3.2.7]
+`
+	assert.Nil(t, parseHostKeyChanged("Host key verification failed.", nil))
+
+	changed := parseHostKeyChanged(stderr, nil)
+	assert.Equal(t, "backup.local", changed.Host)
+	assert.Equal(t, "SHA256:2Xb0Yq8ZLpJw6lP1TfHqgk2eN8eM9bnkGXoG8dA3y2c", changed.Fingerprint)
+
+	err := classifyError(exec.Command("sh", "-c", "exit 255").Run(), stderr)
+	assert.True(t, errors.As(err, &changed))
+	var rsyncErr *RsyncError
+	assert.True(t, errors.As(err, &rsyncErr))
+	assert.Equal(t, 255, rsyncErr.Code)
+	assert.False(t, IsRetryable(err))
+}
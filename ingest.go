@@ -0,0 +1,224 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotRegularFile rejects quarantined symlinks and special files, only regular files are promoted
+var ErrNotRegularFile = errors.New("rsync: not a regular file")
+
+// Inspector decides whether a quarantined file may be promoted; an error rejects the file
+type Inspector interface {
+	Inspect(path string) error
+}
+
+// InspectorFunc is a function used as Inspector
+type InspectorFunc func(path string) error
+
+func (f InspectorFunc) Inspect(path string) error {
+	return f(path)
+}
+
+// ExecInspector runs command with the file path as the last argument, e.g. a virus scanner;
+// non-zero exit rejects the file
+type ExecInspector struct {
+	Command []string
+	// Context for exec
+	Context context.Context
+}
+
+func (i ExecInspector) Inspect(path string) error {
+	if len(i.Command) == 0 {
+		return errors.New("rsync: empty inspector command")
+	}
+
+	arguments := append(append([]string{}, i.Command[1:]...), path)
+	var cmd *exec.Cmd
+	if i.Context == nil {
+		cmd = exec.Command(i.Command[0], arguments...)
+	} else {
+		cmd = exec.CommandContext(i.Context, i.Command[0], arguments...)
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// IngestOptions for Ingest
+type IngestOptions struct {
+	// Quarantine is a directory for new files on the same filesystem as the live tree, every run
+	// uses its own subdirectory there; by default a hidden directory next to the live tree
+	Quarantine string
+	// Inspector checks every new file
+	Inspector Inspector
+	// Workers is a number of files inspected at once; by default 1
+	Workers int
+	// KeepRejected leaves rejected files in the run directory, otherwise it's removed
+	KeepRejected bool
+}
+
+// RejectedFile is a file refused by Inspector
+type RejectedFile struct {
+	// Name is a path relative to the live tree
+	Name string
+	Err  error
+}
+
+// IngestReport lists promoted and rejected files
+type IngestReport struct {
+	// Dir is the quarantine subdirectory of the run
+	Dir      string
+	Promoted []string
+	Rejected []RejectedFile
+}
+
+// Ingest syncs source into the quarantine with CompareDest against the live tree, so only new
+// and changed files land there, inspects them and moves accepted ones into the live tree by rename.
+// The live tree must be local.
+func Ingest(source []string, live string, rsyncOptions RsyncOptions, options IngestOptions) (IngestReport, error) {
+	if isRemotePath(live) {
+		return IngestReport{}, ErrLocalEndpoint
+	}
+	if options.Inspector == nil {
+		return IngestReport{}, errors.New("rsync: inspector is required")
+	}
+
+	live, err := filepath.Abs(live)
+	if err != nil {
+		return IngestReport{}, err
+	}
+	if err = os.MkdirAll(live, 0755); err != nil {
+		return IngestReport{}, err
+	}
+	quarantine := options.Quarantine
+	if quarantine == "" {
+		quarantine = filepath.Join(filepath.Dir(live), "."+filepath.Base(live)+".quarantine")
+		defer func() {
+			// other runs may still use it
+			_ = os.Remove(quarantine)
+		}()
+	}
+	if err = os.MkdirAll(quarantine, 0700); err != nil {
+		return IngestReport{}, err
+	}
+	dir, err := os.MkdirTemp(quarantine, "run-")
+	if err != nil {
+		return IngestReport{}, err
+	}
+	rsyncOptions.CompareDest = live
+	task := NewTask(source, dir+string(filepath.Separator), rsyncOptions)
+	if err = task.Run(); err != nil {
+		return IngestReport{Dir: dir}, err
+	}
+
+	names, err := quarantinedFiles(dir)
+	if err != nil {
+		return IngestReport{Dir: dir}, err
+	}
+
+	report := inspect(dir, names, options)
+	report.Dir = dir
+	for _, name := range report.Promoted {
+		if err = promote(dir, live, name); err != nil {
+			return report, err
+		}
+	}
+
+	if !options.KeepRejected {
+		err = os.RemoveAll(dir)
+	}
+	return report, err
+}
+
+// quarantinedFiles returns relative names of all entries except directories
+func quarantinedFiles(quarantine string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(quarantine, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(quarantine, path)
+		names = append(names, name)
+		return err
+	})
+	return names, err
+}
+
+func inspect(quarantine string, names []string, options IngestOptions) IngestReport {
+	workers := options.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var report IngestReport
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range queue {
+				err := inspectFile(filepath.Join(quarantine, name), options.Inspector)
+
+				mu.Lock()
+				if err == nil {
+					report.Promoted = append(report.Promoted, name)
+				} else {
+					report.Rejected = append(report.Rejected, RejectedFile{Name: name, Err: err})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		queue <- name
+	}
+	close(queue)
+	wg.Wait()
+
+	sort.Strings(report.Promoted)
+	sort.Slice(report.Rejected, func(i, j int) bool {
+		return report.Rejected[i].Name < report.Rejected[j].Name
+	})
+	return report
+}
+
+// inspectFile rejects symlinks before the inspector could follow them
+func inspectFile(path string, inspector Inspector) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return ErrNotRegularFile
+	}
+	return inspector.Inspect(path)
+}
+
+// promote moves the file into the live tree, rename replaces an older version atomically
+func promote(quarantine, live, name string) error {
+	dir := filepath.Dir(name)
+	if dir != "." {
+		info, err := os.Stat(filepath.Join(quarantine, dir))
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Join(live, dir), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(filepath.Join(quarantine, name), filepath.Join(live, name))
+}
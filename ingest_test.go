@@ -0,0 +1,87 @@
+package grsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// quarantineRsync writes new files into the destination given as the last argument
+const quarantineRsync = `for arg; do echo "$arg"; done > "$(dirname "$0")/calls"
+for dest; do :; done
+mkdir -p "$dest/reports"
+echo "id,value" > "$dest/reports/ok.csv"
+echo "virus" > "$dest/reports/bad.csv"
+echo "id,value,new" > "$dest/old.csv"
+ln -s /etc/passwd "$dest/reports/link.csv"
+`
+
+func TestIngest(t *testing.T) {
+	binary := writeFakeRsync(t, quarantineRsync)
+	root := t.TempDir()
+	live := filepath.Join(root, "live")
+	writeTree(t, live, "old.csv", "other.csv")
+
+	report, err := Ingest([]string{"partner:/outgoing/"}, live, RsyncOptions{RsyncBinaryPath: binary}, IngestOptions{
+		Inspector: ExecInspector{Command: []string{"sh", "-c", `! grep -q virus "$0"`}},
+		Workers:   2,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"old.csv", filepath.Join("reports", "ok.csv")}, report.Promoted)
+	require.Len(t, report.Rejected, 2)
+	assert.Equal(t, filepath.Join("reports", "bad.csv"), report.Rejected[0].Name)
+	assert.Error(t, report.Rejected[0].Err)
+	assert.Equal(t, filepath.Join("reports", "link.csv"), report.Rejected[1].Name)
+	assert.Equal(t, ErrNotRegularFile, report.Rejected[1].Err)
+
+	data, err := os.ReadFile(filepath.Join(live, "old.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "id,value,new\n", string(data))
+	assert.FileExists(t, filepath.Join(live, "reports", "ok.csv"))
+	assert.FileExists(t, filepath.Join(live, "other.csv"))
+	assert.NoFileExists(t, filepath.Join(live, "reports", "bad.csv"))
+	assert.NoFileExists(t, filepath.Join(live, "reports", "link.csv"))
+	assert.NoDirExists(t, filepath.Join(root, ".live.quarantine"))
+
+	args := readCalls(t, binary)[0]
+	assert.Contains(t, args, "--compare-dest\n"+live+"\n")
+	assert.Contains(t, args, report.Dir+"/\n")
+	assert.Equal(t, filepath.Join(root, ".live.quarantine"), filepath.Dir(report.Dir))
+
+	t.Run("keep rejected", func(t *testing.T) {
+		quarantine := filepath.Join(t.TempDir(), "quarantine")
+		report, err := Ingest([]string{"partner:/outgoing/"}, live, RsyncOptions{RsyncBinaryPath: binary}, IngestOptions{
+			Quarantine: quarantine,
+			Inspector: InspectorFunc(func(path string) error {
+				return os.ErrPermission
+			}),
+			KeepRejected: true,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, report.Promoted)
+		assert.Len(t, report.Rejected, 4)
+		assert.Equal(t, quarantine, filepath.Dir(report.Dir))
+		assert.FileExists(t, filepath.Join(report.Dir, "reports", "bad.csv"))
+	})
+
+	t.Run("own quarantine", func(t *testing.T) {
+		quarantine := filepath.Join(t.TempDir(), "quarantine")
+		writeTree(t, quarantine, "user.txt")
+		_, err := Ingest([]string{"partner:/outgoing/"}, live, RsyncOptions{RsyncBinaryPath: binary}, IngestOptions{
+			Quarantine: quarantine,
+			Inspector: InspectorFunc(func(path string) error {
+				return nil
+			}),
+		})
+		require.NoError(t, err)
+
+		entries, err := os.ReadDir(quarantine)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "user.txt", entries[0].Name())
+	})
+}
@@ -0,0 +1,180 @@
+package grsync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyFile keeps RunHistory, it has no .json suffix to stay apart from job records
+const historyFile = ".history"
+
+// JobStatus is a status of a persisted job
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	// JobInterrupted job was running when the scheduler stopped
+	JobInterrupted JobStatus = "interrupted"
+)
+
+// JobRecord is a job persisted by Scheduler
+type JobRecord struct {
+	ID        string    `json:"id"`
+	Job       Job       `json:"job"`
+	Status    JobStatus `json:"status"`
+	CreatedAt time.Time `json:"created at"`
+	UpdatedAt time.Time `json:"updated at"`
+}
+
+// JobStore persists the scheduler queue; finished jobs are deleted
+type JobStore interface {
+	Save(record JobRecord) error
+	Delete(id string) error
+	Load() ([]JobRecord, error)
+}
+
+// RunHistory remembers destination paths of successful runs for {{previous}} templates;
+// the scheduler stores a remote destination without its host
+type RunHistory interface {
+	LastDestination(job string) (string, error)
+	SetLastDestination(job, destination string) error
+}
+
+// FileStore keeps each job record in a JSON file of a directory, it's also RunHistory
+type FileStore struct {
+	dir string
+
+	mu sync.Mutex
+}
+
+// NewFileStore creates directory for job records
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Save writes the record atomically
+func (s *FileStore) Save(record JobRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(s.dir, record.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), s.path(record.ID))
+}
+
+// Delete removes the record
+func (s *FileStore) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Load returns all records ordered by creation time
+func (s *FileStore) Load() ([]JobRecord, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]JobRecord, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var record JobRecord
+		if err = json.Unmarshal(data, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records, nil
+}
+
+// LastDestination returns destination of the last successful run of the job; empty if it never ran
+func (s *FileStore) LastDestination(job string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.history()
+	return history[job], err
+}
+
+// SetLastDestination remembers destination of the successful run of the job
+func (s *FileStore) SetLastDestination(job, destination string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.history()
+	if err != nil {
+		return err
+	}
+	history[job] = destination
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	temp := filepath.Join(s.dir, historyFile+".tmp")
+	if err = os.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, filepath.Join(s.dir, historyFile))
+}
+
+func (s *FileStore) history() (map[string]string, error) {
+	history := map[string]string{}
+	data, err := os.ReadFile(filepath.Join(s.dir, historyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return history, json.Unmarshal(data, &history)
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(id, string(filepath.Separator), "_")+".json")
+}
+
+func newJobID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(id)
+}
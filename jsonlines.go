@@ -0,0 +1,129 @@
+package grsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// JSONLinesVersion is a version of JSONLinesRecord schema, it's increased on incompatible changes
+const JSONLinesVersion = 1
+
+// JSONLinesRecord is a line written by JSONLinesSink for every Event
+type JSONLinesRecord struct {
+	Version int       `json:"version"`
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	// State is set for EventState
+	State *State `json:"state,omitempty"`
+	// File is set for EventFile
+	File *FileEvent `json:"file,omitempty"`
+	// Stats, Guarantees, Error and ExitCode are set for EventResult
+	Stats      *Stats      `json:"stats,omitempty"`
+	Guarantees *Guarantees `json:"guarantees,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	ExitCode   int         `json:"exit code,omitempty"`
+}
+
+// JSONLinesOptions for JSONLinesSink
+type JSONLinesOptions struct {
+	// ProgressInterval is the minimum interval between state records, the latest skipped state
+	// is written before the result; zero writes every state change
+	ProgressInterval time.Duration
+}
+
+// JSONLinesSink writes task events as JSON Lines for external consumers, e.g. to a file,
+// a FIFO or stdout. Every record is written with a single Write call.
+type JSONLinesSink struct {
+	writer  io.Writer
+	options JSONLinesOptions
+
+	mu        sync.Mutex
+	lastState time.Time
+	pending   *Event
+	err       error
+}
+
+// NewJSONLinesSink returns sink writing to writer, pass its Handle to OnEvent
+func NewJSONLinesSink(writer io.Writer, options JSONLinesOptions) *JSONLinesSink {
+	return &JSONLinesSink{writer: writer, options: options}
+}
+
+// Handle writes record of the event; after a write error the sink stops writing
+func (s *JSONLinesSink) Handle(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch event.Type {
+	case EventState:
+		if s.options.ProgressInterval > 0 && !s.lastState.IsZero() &&
+			event.Time.Sub(s.lastState) < s.options.ProgressInterval {
+			s.pending = &event
+			return
+		}
+		s.pending = nil
+		s.lastState = event.Time
+	case EventResult:
+		if s.pending != nil {
+			s.write(*s.pending)
+			s.pending = nil
+		}
+		// the next run of the task starts a new progress series
+		s.lastState = time.Time{}
+	}
+
+	s.write(event)
+}
+
+// Err returns the first write error
+func (s *JSONLinesSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *JSONLinesSink) write(event Event) {
+	if s.err != nil {
+		return
+	}
+
+	// rsync flags like `>f+++++++++` are kept readable for shell tools
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(newJSONLinesRecord(event))
+	if err == nil {
+		_, err = s.writer.Write(buffer.Bytes())
+	}
+	s.err = err
+}
+
+func newJSONLinesRecord(event Event) JSONLinesRecord {
+	record := JSONLinesRecord{
+		Version: JSONLinesVersion,
+		Type:    event.Type,
+		Time:    event.Time,
+	}
+
+	switch event.Type {
+	case EventState:
+		state := event.State
+		record.State = &state
+	case EventFile:
+		record.File = event.File
+	case EventResult:
+		record.Stats = event.Stats
+		record.Guarantees = event.Guarantees
+		if event.Err != nil {
+			record.Error = event.Err.Error()
+			var rsyncErr *RsyncError
+			if errors.As(event.Err, &rsyncErr) {
+				record.ExitCode = rsyncErr.Code
+			}
+		}
+	}
+	return record
+}
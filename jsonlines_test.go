@@ -0,0 +1,104 @@
+package grsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readJSONLines(t *testing.T, data []byte) []JSONLinesRecord {
+	t.Helper()
+
+	var records []JSONLinesRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var record JSONLinesRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		assert.Equal(t, JSONLinesVersion, record.Version)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	binary := writeFakeRsync(t, itemizingRsync+`echo "Number of regular files transferred: 3"
+`)
+	var output bytes.Buffer
+	sink := NewJSONLinesSink(&output, JSONLinesOptions{})
+	task := NewTask([]string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary, ItemizeChanges: true})
+	task.OnEvent(sink.Handle)
+	require.NoError(t, task.Run())
+	require.NoError(t, sink.Err())
+
+	records := readJSONLines(t, output.Bytes())
+	counts := map[EventType]int{}
+	for _, record := range records {
+		counts[record.Type]++
+	}
+	assert.Equal(t, 7, counts[EventFile])
+	assert.True(t, counts[EventState] > 0)
+
+	last := records[len(records)-1]
+	assert.Equal(t, EventResult, last.Type)
+	assert.Equal(t, 3, last.Stats.FilesTransferred)
+	assert.Empty(t, last.Error)
+	assert.Contains(t, output.String(), `"copied object"`)
+	assert.Contains(t, output.String(), `"change":{"flags":">f+++++++++"}`)
+}
+
+func TestJSONLinesSinkRateLimit(t *testing.T) {
+	var output bytes.Buffer
+	sink := NewJSONLinesSink(&output, JSONLinesOptions{ProgressInterval: time.Second})
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := func(offset time.Duration, progress float64) Event {
+		return Event{Type: EventState, Time: start.Add(offset), State: State{Progress: progress}}
+	}
+
+	sink.Handle(state(0, 10))
+	sink.Handle(state(100*time.Millisecond, 20))
+	sink.Handle(Event{Type: EventFile, Time: start, File: &FileEvent{Name: "a"}})
+	sink.Handle(state(time.Second, 30))
+	sink.Handle(state(1500*time.Millisecond, 40))
+	sink.Handle(state(1600*time.Millisecond, 50))
+	sink.Handle(Event{Type: EventResult, Time: start.Add(2 * time.Second), Err: &RsyncError{Code: 23, Message: "partial transfer"}})
+
+	records := readJSONLines(t, output.Bytes())
+	var types []EventType
+	var progress []float64
+	for _, record := range records {
+		types = append(types, record.Type)
+		if record.State != nil {
+			progress = append(progress, record.State.Progress)
+		}
+	}
+	assert.Equal(t, []EventType{EventState, EventFile, EventState, EventState, EventResult}, types)
+	assert.Equal(t, []float64{10, 30, 50}, progress)
+
+	result := records[len(records)-1]
+	assert.Equal(t, 23, result.ExitCode)
+	assert.NotEmpty(t, result.Error)
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("broken pipe")
+}
+
+func TestJSONLinesSinkWriteError(t *testing.T) {
+	writer := &failingWriter{}
+	sink := NewJSONLinesSink(writer, JSONLinesOptions{})
+	sink.Handle(Event{Type: EventState})
+	sink.Handle(Event{Type: EventState})
+	assert.EqualError(t, sink.Err(), "broken pipe")
+	assert.Equal(t, 1, writer.writes)
+}
@@ -0,0 +1,65 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrDestinationLocked is returned when another process holds the destination lock
+var ErrDestinationLocked = errors.New("rsync: destination is locked")
+
+// DestinationLock is an exclusive advisory lock of a local destination shared by processes.
+// The lock file is kept next to the destination, so --delete never removes it.
+type DestinationLock struct {
+	file *os.File
+}
+
+// LockDestination locks the destination directory; without wait it fails with ErrDestinationLocked
+// if the lock is held
+func LockDestination(destination string, wait bool) (*DestinationLock, error) {
+	destination, err := filepath.Abs(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(filepath.Dir(destination), "."+filepath.Base(destination)+".lock")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = flock(file, wait); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &DestinationLock{file: file}, nil
+}
+
+// Unlock releases the lock
+func (l *DestinationLock) Unlock() error {
+	return l.file.Close()
+}
+
+// Lock returns middleware which holds the destination lock while the task runs;
+// remote destinations are not locked
+func Lock(wait bool) Middleware {
+	return func(next Runner) Runner {
+		return RunnerFunc(func(task *Task) error {
+			destination := task.rsync.Destination
+			if destination == "" || isRemotePath(destination) {
+				return next.Run(task)
+			}
+
+			lock, err := LockDestination(destination, wait)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = lock.Unlock()
+			}()
+
+			return next.Run(task)
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package grsync
+
+import (
+	"errors"
+	"os"
+)
+
+func flock(file *os.File, wait bool) error {
+	return errors.New("rsync: destination lock is not supported on this platform")
+}
@@ -0,0 +1,78 @@
+package grsync
+
+import (
+	"time"
+)
+
+// Runner runs the task
+type Runner interface {
+	Run(task *Task) error
+}
+
+// RunnerFunc is an adapter to use functions as Runner
+type RunnerFunc func(task *Task) error
+
+// Run calls f(task)
+func (f RunnerFunc) Run(task *Task) error {
+	return f(task)
+}
+
+// Middleware wraps Runner with cross-cutting concerns, e.g. locking, metrics or retries
+type Middleware func(next Runner) Runner
+
+// Use adds middleware to the task; the first added middleware is the outermost one
+func (t *Task) Use(middleware ...Middleware) {
+	t.middleware = append(t.middleware, middleware...)
+}
+
+// chain wraps runner so that middleware[0] is called first
+func chain(runner Runner, middleware []Middleware) Runner {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		runner = middleware[i](runner)
+	}
+	return runner
+}
+
+// Retry reruns the task up to attempts times while it fails with retryable errors
+func Retry(attempts int, delay time.Duration) Middleware {
+	return func(next Runner) Runner {
+		return RunnerFunc(func(task *Task) error {
+			err := next.Run(task)
+			for attempt := 1; attempt < attempts && IsRetryable(err); attempt++ {
+				if ctx := task.options.RsyncContext; ctx != nil {
+					select {
+					case <-ctx.Done():
+						return err
+					case <-time.After(delay):
+					}
+				} else {
+					time.Sleep(delay)
+				}
+
+				task.restart()
+				err = next.Run(task)
+			}
+			return err
+		})
+	}
+}
+
+// Hooks calls before prior to running the task and after with its result;
+// an error of before cancels the run
+func Hooks(before func(task *Task) error, after func(task *Task, err error)) Middleware {
+	return func(next Runner) Runner {
+		return RunnerFunc(func(task *Task) error {
+			if before != nil {
+				if err := before(task); err != nil {
+					return err
+				}
+			}
+
+			err := next.Run(task)
+			if after != nil {
+				after(task, err)
+			}
+			return err
+		})
+	}
+}
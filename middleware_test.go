@@ -0,0 +1,85 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyRsync fails with socket error on the first two runs and succeeds on the third
+const flakyRsync = `attempts="$(dirname "$0")/attempts"
+echo run >> "$attempts"
+if [ "$(wc -l < "$attempts")" -lt 3 ]; then
+	echo "rsync error: error in socket IO (code 10)" >&2
+	exit 10
+fi
+echo "Number of regular files transferred: 1"
+`
+
+func TestMiddlewareOrder(t *testing.T) {
+	binary := writeFakeRsync(t, "")
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Runner) Runner {
+			return RunnerFunc(func(task *Task) error {
+				calls = append(calls, name+" before")
+				err := next.Run(task)
+				calls = append(calls, name+" after")
+				return err
+			})
+		}
+	}
+
+	task := NewTask([]string{"a"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary, Delete: true})
+	task.Use(trace("outer"), trace("middle"))
+	task.Use(Hooks(func(task *Task) error {
+		assert.True(t, task.Options().Delete)
+		calls = append(calls, "hook before")
+		return nil
+	}, func(task *Task, err error) {
+		calls = append(calls, "hook after")
+	}))
+
+	assert.NoError(t, task.Run())
+	assert.Equal(t, []string{"outer before", "middle before", "hook before", "hook after", "middle after", "outer after"}, calls)
+}
+
+func TestHooksCancelRun(t *testing.T) {
+	binary := writeFakeRsync(t, "echo started")
+	locked := errors.New("locked")
+
+	task := NewTask([]string{"a"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary})
+	task.Use(Hooks(func(task *Task) error {
+		return locked
+	}, nil))
+
+	assert.Equal(t, locked, task.Run())
+	assert.Empty(t, task.Log().Stdout)
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("retries transient errors", func(t *testing.T) {
+		binary := writeFakeRsync(t, flakyRsync)
+		task := NewTask([]string{"a"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary})
+		task.Use(Retry(3, time.Millisecond))
+
+		assert.NoError(t, task.Run())
+		assert.Equal(t, 1, task.Stats().FilesTransferred)
+		assert.Empty(t, task.Log().Stderr)
+	})
+
+	t.Run("gives up after attempts", func(t *testing.T) {
+		binary := writeFakeRsync(t, flakyRsync)
+		task := NewTask([]string{"a"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary})
+		task.Use(Retry(2, time.Millisecond))
+
+		assert.True(t, IsRetryable(task.Run()))
+		attempts, err := os.ReadFile(filepath.Join(filepath.Dir(binary), "attempts"))
+		assert.NoError(t, err)
+		assert.Equal(t, "run\nrun\n", string(attempts))
+	})
+}
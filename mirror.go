@@ -0,0 +1,209 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultProbeTimeout = 10 * time.Second
+
+// ErrNoMirrors is returned when no mirror could be used
+var ErrNoMirrors = errors.New("rsync: no available mirrors")
+
+// MirrorOptions for MirrorTask
+type MirrorOptions struct {
+	// Probe checks mirrors with --list-only and tries the fastest first
+	Probe bool
+	// ProbeTimeout limits a single probe; by default 10 seconds
+	ProbeTimeout time.Duration
+}
+
+// MirrorAttempt contains information about one mirror used by MirrorTask
+type MirrorAttempt struct {
+	Mirror  Endpoint      `json:"mirror"`
+	Latency time.Duration `json:"latency"`
+	Probe   bool          `json:"probe"`
+	Err     error         `json:"-"`
+}
+
+// MirrorResult contains information about MirrorTask run
+type MirrorResult struct {
+	// Mirror served the data; empty if all mirrors failed
+	Mirror   Endpoint        `json:"mirror"`
+	Attempts []MirrorAttempt `json:"attempts"`
+}
+
+// MirrorTask syncs from one of equivalent source mirrors,
+// continuing from the next mirror on retryable failure
+type MirrorTask struct {
+	mirrors      []Endpoint
+	destination  string
+	rsyncOptions RsyncOptions
+	options      MirrorOptions
+
+	mu     sync.Mutex
+	task   *Task
+	result MirrorResult
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// NewMirrorTask returns new task which syncs from mirrors in the given order
+func NewMirrorTask(mirrors []Endpoint, destination string, rsyncOptions RsyncOptions, options MirrorOptions) *MirrorTask {
+	return &MirrorTask{
+		mirrors:      mirrors,
+		destination:  destination,
+		rsyncOptions: rsyncOptions,
+		options:      options,
+		task:         NewTask(nil, destination, rsyncOptions),
+		stdout:       io.Discard,
+		stderr:       io.Discard,
+	}
+}
+
+func (m *MirrorTask) SetStdout(stdout io.Writer) {
+	m.stdout = stdout
+}
+
+func (m *MirrorTask) SetStderr(stderr io.Writer) {
+	m.stderr = stderr
+}
+
+// State returns information about the current mirror task
+func (m *MirrorTask) State() State {
+	return m.currentTask().State()
+}
+
+// Log returns outputs of the current mirror task
+func (m *MirrorTask) Log() Log {
+	return m.currentTask().Log()
+}
+
+// Result returns served mirror and all attempts
+func (m *MirrorTask) Result() MirrorResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := m.result
+	result.Attempts = append([]MirrorAttempt(nil), m.result.Attempts...)
+	return result
+}
+
+// Run syncs from the first mirror which succeeds
+func (m *MirrorTask) Run() error {
+	mirrors := m.mirrors
+	if m.options.Probe {
+		mirrors = m.probe()
+	}
+
+	err := ErrNoMirrors
+	for _, mirror := range mirrors {
+		task := NewTask([]string{mirror.String()}, m.destination, mirror.Options(m.rsyncOptions))
+		task.SetStdout(m.stdout)
+		task.SetStderr(m.stderr)
+		m.setCurrentTask(task)
+
+		startedAt := time.Now()
+		err = task.Run()
+		m.addAttempt(MirrorAttempt{Mirror: mirror, Latency: time.Since(startedAt), Err: err})
+		if err == nil {
+			m.mu.Lock()
+			m.result.Mirror = mirror
+			m.mu.Unlock()
+			return nil
+		}
+		if !IsRetryable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// probe lists every mirror and returns reachable ones ordered by latency
+func (m *MirrorTask) probe() []Endpoint {
+	attempts := make([]MirrorAttempt, len(m.mirrors))
+
+	var wg sync.WaitGroup
+	for i, mirror := range m.mirrors {
+		wg.Add(1)
+		go func(i int, mirror Endpoint) {
+			defer wg.Done()
+			attempts[i] = m.probeMirror(mirror)
+		}(i, mirror)
+	}
+	wg.Wait()
+
+	sort.SliceStable(attempts, func(i, j int) bool {
+		if (attempts[i].Err == nil) != (attempts[j].Err == nil) {
+			return attempts[i].Err == nil
+		}
+		return attempts[i].Latency < attempts[j].Latency
+	})
+
+	var mirrors []Endpoint
+	for _, attempt := range attempts {
+		m.addAttempt(attempt)
+		if attempt.Err == nil {
+			mirrors = append(mirrors, attempt.Mirror)
+		}
+	}
+	return mirrors
+}
+
+func (m *MirrorTask) probeMirror(mirror Endpoint) MirrorAttempt {
+	timeout := m.options.ProbeTimeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	parent := m.rsyncOptions.RsyncContext
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	// only options required to connect are used
+	options := mirror.Options(RsyncOptions{
+		RsyncBinaryPath:   m.rsyncOptions.RsyncBinaryPath,
+		SSHPassBinaryPath: m.rsyncOptions.SSHPassBinaryPath,
+		SSHPassword:       m.rsyncOptions.SSHPassword,
+		RsyncContext:      ctx,
+		RsyncPath:         m.rsyncOptions.RsyncPath,
+		Rsh:               m.rsyncOptions.Rsh,
+		PasswordFile:      m.rsyncOptions.PasswordFile,
+		Contimeout:        m.rsyncOptions.Contimeout,
+		IPv4:              m.rsyncOptions.IPv4,
+		IPv6:              m.rsyncOptions.IPv6,
+		ListOnly:          true,
+	})
+
+	startedAt := time.Now()
+	err := NewTaskWithoutForceOptions([]string{mirror.String()}, "", options).Run()
+
+	return MirrorAttempt{Mirror: mirror, Latency: time.Since(startedAt), Probe: true, Err: err}
+}
+
+func (m *MirrorTask) currentTask() *Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.task
+}
+
+func (m *MirrorTask) setCurrentTask(task *Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.task = task
+}
+
+func (m *MirrorTask) addAttempt(attempt MirrorAttempt) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.result.Attempts = append(m.result.Attempts, attempt)
+}
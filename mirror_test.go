@@ -0,0 +1,64 @@
+package grsync
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mirrorRsync fails or sleeps depending on mirror host name
+const mirrorRsync = `for arg; do
+	case "$arg" in
+	broken:*) echo "rsync error: error in socket IO (code 10)" >&2; exit 10 ;;
+	denied:*) echo "rsync error: some files could not be transferred (code 23)" >&2; exit 23 ;;
+	slow:*) sleep 0.3 ;;
+	esac
+done
+`
+
+func TestMirrorTask(t *testing.T) {
+	binary := writeFakeRsync(t, mirrorRsync)
+
+	t.Run("fails over to the next mirror", func(t *testing.T) {
+		mirrors := []Endpoint{{Host: "broken", Path: "/pub"}, {Host: "good", Path: "/pub"}}
+		task := NewMirrorTask(mirrors, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}, MirrorOptions{})
+
+		assert.NoError(t, task.Run())
+		result := task.Result()
+		assert.Equal(t, mirrors[1], result.Mirror)
+		assert.Len(t, result.Attempts, 2)
+		assert.True(t, IsRetryable(result.Attempts[0].Err))
+	})
+
+	t.Run("stops on not retryable error", func(t *testing.T) {
+		mirrors := []Endpoint{{Host: "denied", Path: "/pub"}, {Host: "good", Path: "/pub"}}
+		task := NewMirrorTask(mirrors, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}, MirrorOptions{})
+
+		err := task.Run()
+		var rsyncErr *RsyncError
+		assert.True(t, errors.As(err, &rsyncErr))
+		assert.Equal(t, 23, rsyncErr.Code)
+		assert.Empty(t, task.Result().Mirror)
+	})
+
+	t.Run("ranks mirrors by latency", func(t *testing.T) {
+		mirrors := []Endpoint{{Host: "slow", Path: "/pub"}, {Host: "broken", Path: "/pub"}, {Host: "fast", Path: "/pub"}}
+		task := NewMirrorTask(mirrors, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}, MirrorOptions{Probe: true})
+
+		assert.NoError(t, task.Run())
+		result := task.Result()
+		assert.Equal(t, mirrors[2], result.Mirror)
+		assert.Len(t, result.Attempts, 4)
+		assert.Equal(t, mirrors[2], result.Attempts[0].Mirror)
+		assert.True(t, result.Attempts[0].Probe)
+	})
+
+	t.Run("probe timeout", func(t *testing.T) {
+		mirrors := []Endpoint{{Host: "slow", Path: "/pub"}}
+		task := NewMirrorTask(mirrors, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}, MirrorOptions{Probe: true, ProbeTimeout: 50 * time.Millisecond})
+
+		assert.True(t, errors.Is(task.Run(), ErrNoMirrors))
+	})
+}
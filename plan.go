@@ -0,0 +1,143 @@
+package grsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPlanModified is returned when the plan doesn't match its fingerprint, e.g. it was edited after approval
+var ErrPlanModified = errors.New("rsync: plan doesn't match its fingerprint")
+
+// PlanDriftError is returned by Apply when the real run would make changes which are not in the plan
+type PlanDriftError struct {
+	// Unexpected are changes absent from the approved plan
+	Unexpected []FileEvent
+}
+
+func (e *PlanDriftError) Error() string {
+	return fmt.Sprintf("rsync: plan drifted, %d unexpected changes, first: %s %s",
+		len(e.Unexpected), e.Unexpected[0].Change.Flags, e.Unexpected[0].Name)
+}
+
+// Plan is a change set shown by a dry run, it's applied only while the real run stays within it
+type Plan struct {
+	Source      []string     `json:"source"`
+	Destination string       `json:"destination"`
+	Options     RsyncOptions `json:"options"`
+	Changes     []FileEvent  `json:"changes"`
+	CreatedAt   time.Time    `json:"created at"`
+	// Fingerprint covers source, destination, options and changes
+	Fingerprint string `json:"fingerprint"`
+}
+
+// NewPlan makes a dry run and returns its change set
+func NewPlan(source []string, destination string, rsyncOptions RsyncOptions) (*Plan, error) {
+	changes, err := dryRun(source, destination, rsyncOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	rsyncOptions.RsyncContext = nil
+	plan := &Plan{
+		Source:      source,
+		Destination: destination,
+		Options:     rsyncOptions,
+		Changes:     changes,
+		CreatedAt:   time.Now(),
+	}
+	if plan.Fingerprint, err = plan.fingerprint(); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// Deletions returns files the plan deletes
+func (p *Plan) Deletions() []FileEvent {
+	var deletions []FileEvent
+	for _, change := range p.Changes {
+		if change.Change.Deleted() {
+			deletions = append(deletions, change)
+		}
+	}
+	return deletions
+}
+
+// Check makes a dry run again and returns PlanDriftError if it shows changes absent from the plan,
+// e.g. new deletions. Changes of approved files which differ only in attributes are accepted,
+// while another update type or a content change of an approved file is not.
+func (p *Plan) Check() error {
+	fingerprint, err := p.fingerprint()
+	if err != nil {
+		return err
+	}
+	if fingerprint != p.Fingerprint {
+		return ErrPlanModified
+	}
+
+	changes, err := dryRun(p.Source, p.Destination, p.Options)
+	if err != nil {
+		return err
+	}
+
+	approved := make(map[planKey]bool, len(p.Changes))
+	for _, change := range p.Changes {
+		approved[newPlanKey(change)] = true
+	}
+	var unexpected []FileEvent
+	for _, change := range changes {
+		if !approved[newPlanKey(change)] {
+			unexpected = append(unexpected, change)
+		}
+	}
+	if len(unexpected) > 0 {
+		return &PlanDriftError{Unexpected: unexpected}
+	}
+	return nil
+}
+
+// Apply runs the plan after Check; the returned task holds log and stats of the run
+func (p *Plan) Apply() (*Task, error) {
+	if err := p.Check(); err != nil {
+		return nil, err
+	}
+
+	task := NewTask(p.Source, p.Destination, p.Options)
+	return task, task.Run()
+}
+
+func (p *Plan) fingerprint() (string, error) {
+	plan := *p
+	plan.Fingerprint = ""
+	plan.CreatedAt = time.Time{}
+	plan.Options.RsyncContext = nil
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// planKey matches changes of the same file, update type and content; attributes are not compared
+type planKey struct {
+	name string
+	// update is the update type, e.g. `>` for a transfer or `*` for a deletion
+	update  byte
+	content bool
+}
+
+func newPlanKey(change FileEvent) planKey {
+	key := planKey{name: change.Name}
+	flags := change.Change.Flags
+	if flags != "" {
+		key.update = flags[0]
+	}
+	// a new file or a changed checksum or size means other data
+	key.content = change.Change.Created() || len(flags) > 3 && (flags[2] == 'c' || flags[3] == 's')
+	return key
+}
@@ -0,0 +1,73 @@
+package grsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	binary := writeFakeRsync(t, `for arg; do echo "$arg"; done >> "$(dirname "$0")/calls"
+echo "---" >> "$(dirname "$0")/calls"
+cat "$(dirname "$0")/changes"`)
+	setChanges := func(changes string) {
+		require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(binary), "changes"), []byte(changes), 0600))
+	}
+
+	setChanges(">f+++++++++ data/a.jpg\n.f...p..... data/b.jpg\n*deleting   data/old.jpg\n")
+	plan, err := NewPlan([]string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary, Delete: true})
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 3)
+	assert.Equal(t, "data/old.jpg", plan.Deletions()[0].Name)
+	assert.NotEmpty(t, plan.Fingerprint)
+
+	t.Run("attributes changed", func(t *testing.T) {
+		setChanges(">f.st...... data/a.jpg\n.f....o.... data/b.jpg\n*deleting   data/old.jpg\n")
+		assert.NoError(t, plan.Check())
+	})
+
+	t.Run("content changed", func(t *testing.T) {
+		setChanges(">f+++++++++ data/a.jpg\n>f.s....... data/b.jpg\n*deleting   data/old.jpg\n")
+		err := plan.Check()
+
+		var drift *PlanDriftError
+		require.True(t, errors.As(err, &drift))
+		require.Len(t, drift.Unexpected, 1)
+		assert.Equal(t, "data/b.jpg", drift.Unexpected[0].Name)
+	})
+
+	t.Run("new deletion", func(t *testing.T) {
+		setChanges(">f+++++++++ data/a.jpg\n*deleting   data/old.jpg\n*deleting   data/important.db\n")
+		err := plan.Check()
+
+		var drift *PlanDriftError
+		require.True(t, errors.As(err, &drift))
+		require.Len(t, drift.Unexpected, 1)
+		assert.Equal(t, "data/important.db", drift.Unexpected[0].Name)
+		assert.True(t, drift.Unexpected[0].Change.Deleted())
+
+		_, err = plan.Apply()
+		assert.True(t, errors.As(err, &drift))
+	})
+
+	t.Run("modified plan", func(t *testing.T) {
+		modified := *plan
+		modified.Changes = append(modified.Changes, FileEvent{Name: "data/important.db", Change: ItemizedChange{Flags: deletingFlags}})
+		assert.Equal(t, ErrPlanModified, modified.Check())
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		setChanges(">f+++++++++ data/a.jpg\n")
+		_, err := plan.Apply()
+		require.NoError(t, err)
+
+		calls := readCalls(t, binary)
+		assert.Contains(t, calls[len(calls)-2], "--dry-run\n")
+		assert.NotContains(t, calls[len(calls)-1], "--dry-run\n")
+		assert.Contains(t, calls[len(calls)-1], "--delete\n")
+	})
+}
@@ -0,0 +1,131 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// installKeyCommand appends the key read from stdin to authorized_keys unless it's already there
+const installKeyCommand = `umask 077 && mkdir -p ~/.ssh && read -r key && touch ~/.ssh/authorized_keys && ` +
+	`{ grep -qxF "$key" ~/.ssh/authorized_keys || printf '%s\n' "$key" >> ~/.ssh/authorized_keys; }`
+
+// ErrKeyNotAccepted is returned by Provision when the installed key doesn't give access
+var ErrKeyNotAccepted = errors.New("rsync: installed key is not accepted by the remote host")
+
+// ProvisionOptions for Provision
+type ProvisionOptions struct {
+	// KeyPath is a path to the private key, public key gets `.pub` suffix; an existing key is reused
+	KeyPath string
+	// Comment is a comment of the public key
+	Comment string
+	// ForcedCommand restricts the key to the command, e.g. `rrsync /backups`
+	ForcedCommand string
+	// Restrict disables forwarding and pty for the key
+	Restrict bool
+	// SSHPassword is used for the initial session
+	SSHPassword string
+	// SSHPassBinaryPath is a path to the sshpass binary; by default just `sshpass`
+	SSHPassBinaryPath string
+	// SSHKeygenBinaryPath is a path to the ssh-keygen binary; by default just `ssh-keygen`
+	SSHKeygenBinaryPath string
+	// SSH options used for connections, e.g. from HostKeyStore
+	SSH SSHOptions
+	// Context for exec
+	Context context.Context
+}
+
+// Provision generates a dedicated ed25519 key, installs it on the endpoint
+// through a password authenticated session and verifies key based access
+func Provision(endpoint Endpoint, options ProvisionOptions) (SSHOptions, error) {
+	if endpoint.IsLocal() {
+		return SSHOptions{}, ErrLocalEndpoint
+	}
+	if options.KeyPath == "" {
+		return SSHOptions{}, errors.New("rsync: key path is required")
+	}
+
+	if err := options.generateKey(); err != nil {
+		return SSHOptions{}, err
+	}
+	public, err := os.ReadFile(options.KeyPath + ".pub")
+	if err != nil {
+		return SSHOptions{}, err
+	}
+
+	ssh := options.SSH
+	if endpoint.Port > 0 {
+		ssh.Port = endpoint.Port
+	}
+
+	install := options.command(ssh, RsyncOptions{
+		SSHPassword:       options.SSHPassword,
+		SSHPassBinaryPath: options.SSHPassBinaryPath,
+	}, endpoint, installKeyCommand)
+	install.Stdin = strings.NewReader(options.authorizedKey(string(public)) + "\n")
+	if output, err := install.CombinedOutput(); err != nil {
+		return SSHOptions{}, fmt.Errorf("rsync: install key: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	ssh.IdentityFile = options.KeyPath
+	ssh = ssh.With("IdentitiesOnly=yes")
+
+	verify := options.command(ssh.With("BatchMode=yes", "PasswordAuthentication=no"), RsyncOptions{}, endpoint, "true")
+	if err := verify.Run(); err != nil {
+		// a forced command may fail, only ssh itself exits with 255
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() == exitRemoteShell {
+			return SSHOptions{}, ErrKeyNotAccepted
+		}
+	}
+
+	return ssh, nil
+}
+
+func (o ProvisionOptions) generateKey() error {
+	if _, err := os.Stat(o.KeyPath); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(o.KeyPath), 0700); err != nil {
+		return err
+	}
+
+	binaryPath := "ssh-keygen"
+	if o.SSHKeygenBinaryPath != "" {
+		binaryPath = o.SSHKeygenBinaryPath
+	}
+	output, err := exec.Command(binaryPath, "-q", "-t", "ed25519", "-N", "", "-C", o.Comment, "-f", o.KeyPath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rsync: generate key: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// authorizedKey returns authorized_keys line with restrictions
+func (o ProvisionOptions) authorizedKey(public string) string {
+	var restrictions []string
+	if o.Restrict {
+		restrictions = append(restrictions, "restrict")
+	}
+	if o.ForcedCommand != "" {
+		restrictions = append(restrictions, `command="`+strings.ReplaceAll(o.ForcedCommand, `"`, `\"`)+`"`)
+	}
+
+	public = strings.TrimSpace(public)
+	if len(restrictions) == 0 {
+		return public
+	}
+	return strings.Join(restrictions, ",") + " " + public
+}
+
+func (o ProvisionOptions) command(ssh SSHOptions, rsyncOptions RsyncOptions, endpoint Endpoint, command string) *exec.Cmd {
+	binaryPath, arguments := ssh.command()
+	arguments = append(arguments, endpoint.Address(), command)
+
+	rsyncOptions.RsyncContext = o.Context
+	return newCommand(binaryPath, arguments, rsyncOptions)
+}
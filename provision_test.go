@@ -0,0 +1,88 @@
+package grsync
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSSHPass records the password and runs the command
+const fakeSSHPass = `echo "$2" > "$(dirname "$0")/password"
+shift 2
+exec "$@"
+`
+
+func TestProvision(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is required")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	ssh := writeFakeRsync(t, fakeSSH)
+	sshpass := writeFakeRsync(t, fakeSSHPass)
+	keyPath := filepath.Join(t.TempDir(), "keys", "backup")
+	endpoint := Endpoint{User: "backup", Host: "target", Port: 2222}
+	options := ProvisionOptions{
+		KeyPath:           keyPath,
+		Comment:           "grsync backup",
+		ForcedCommand:     `rrsync "/backups"`,
+		Restrict:          true,
+		SSHPassword:       "secret",
+		SSHPassBinaryPath: sshpass,
+		SSH:               SSHOptions{BinaryPath: ssh, Options: []string{"StrictHostKeyChecking=yes"}},
+	}
+
+	sshOptions, err := Provision(endpoint, options)
+	assert.NoError(t, err)
+	assert.Equal(t, SSHOptions{
+		BinaryPath:   ssh,
+		Port:         2222,
+		IdentityFile: keyPath,
+		Options:      []string{"StrictHostKeyChecking=yes", "IdentitiesOnly=yes"},
+	}, sshOptions)
+
+	password, err := os.ReadFile(filepath.Join(filepath.Dir(sshpass), "password"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret\n", string(password))
+
+	public, err := os.ReadFile(keyPath + ".pub")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(public), "ssh-ed25519 "))
+
+	authorized, err := os.ReadFile(filepath.Join(home, ".ssh", "authorized_keys"))
+	assert.NoError(t, err)
+	assert.Equal(t, `restrict,command="rrsync \"/backups\"" `+strings.TrimSpace(string(public))+"\n", string(authorized))
+
+	t.Run("existing key is reused and installed once", func(t *testing.T) {
+		_, err := Provision(endpoint, options)
+		assert.NoError(t, err)
+
+		reused, err := os.ReadFile(keyPath + ".pub")
+		assert.NoError(t, err)
+		assert.Equal(t, public, reused)
+
+		again, err := os.ReadFile(filepath.Join(home, ".ssh", "authorized_keys"))
+		assert.NoError(t, err)
+		assert.Equal(t, authorized, again)
+	})
+
+	t.Run("key is not accepted", func(t *testing.T) {
+		rejecting := writeFakeRsync(t, `case "$*" in *BatchMode=yes*) exit 255 ;; esac
+`+fakeSSH)
+		options := options
+		options.SSH.BinaryPath = rejecting
+
+		_, err := Provision(endpoint, options)
+		assert.Equal(t, ErrKeyNotAccepted, err)
+	})
+}
+
+func TestAuthorizedKey(t *testing.T) {
+	assert.Equal(t, "ssh-ed25519 AAAA c", ProvisionOptions{}.authorizedKey("ssh-ed25519 AAAA c\n"))
+	assert.Equal(t, "restrict ssh-ed25519 AAAA", ProvisionOptions{Restrict: true}.authorizedKey("ssh-ed25519 AAAA"))
+}
@@ -0,0 +1,301 @@
+package grsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrQuotaExceeded is returned for jobs of a tenant over its daily budget
+	ErrQuotaExceeded = errors.New("rsync: tenant transfer quota exceeded")
+	// ErrByteCapReached is returned when rsync is stopped by the per-run byte cap
+	ErrByteCapReached = errors.New("rsync: per-run byte cap reached")
+)
+
+// QuotaPolicy decides what happens to jobs of a tenant over budget
+type QuotaPolicy int
+
+const (
+	// QuotaReject fails the job with ErrQuotaExceeded
+	QuotaReject QuotaPolicy = iota
+	// QuotaDefer holds the job until the budget is renewed
+	QuotaDefer
+)
+
+// QuotaOptions for Quota
+type QuotaOptions struct {
+	// DailyBytes is a budget of bytes sent and received by a tenant per day; zero is unlimited
+	DailyBytes int64
+	// Tenants overrides DailyBytes for particular tenants
+	Tenants map[string]int64
+	// Policy for jobs over budget
+	Policy QuotaPolicy
+	// RunBytes caps a single run; zero is unlimited
+	RunBytes int64
+	// Now returns current time, days are counted in its location; by default time.Now
+	Now func() time.Time
+}
+
+// Quota accounts bytes moved by tenants per day
+type Quota struct {
+	options QuotaOptions
+
+	mu    sync.Mutex
+	usage map[string]tenantUsage
+	// reserved are bytes held by running runs of tenants
+	reserved map[string]int64
+	changed  chan struct{}
+}
+
+type tenantUsage struct {
+	day   string
+	bytes int64
+}
+
+// NewQuota returns quota with empty usage
+func NewQuota(options QuotaOptions) *Quota {
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	return &Quota{
+		options:  options,
+		usage:    map[string]tenantUsage{},
+		reserved: map[string]int64{},
+		changed:  make(chan struct{}),
+	}
+}
+
+// Account adds bytes sent and received by the run, rsync should be started with Stats option.
+// Bytes counted from progress output are charged when they are more, e.g. for a stopped run.
+func (q *Quota) Account(tenant string, stats Stats) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.account(tenant, stats)
+}
+
+// Reserve returns byte cap of a run like RunCap and holds it from the budget of the tenant
+// until Settle, so concurrent runs don't overshoot the budget
+func (q *Quota) Reserve(tenant string, runBytes int64) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	limit := q.runCap(tenant, runBytes)
+	if _, limited := q.remaining(tenant); limited {
+		q.reserved[tenant] += limit
+	}
+	return limit
+}
+
+// Settle releases the reservation of the run and accounts its stats
+func (q *Quota) Settle(tenant string, reserved int64, stats Stats) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, limited := q.remaining(tenant); limited {
+		q.reserved[tenant] -= reserved
+		if q.reserved[tenant] <= 0 {
+			delete(q.reserved, tenant)
+		}
+	}
+	q.account(tenant, stats)
+}
+
+func (q *Quota) account(tenant string, stats Stats) {
+	bytes := stats.BytesSent + stats.BytesReceived
+	if stats.ProgressBytes > bytes {
+		bytes = stats.ProgressBytes
+	}
+
+	usage := q.current(tenant)
+	usage.bytes += bytes
+	q.usage[tenant] = usage
+}
+
+// Usage returns bytes moved by the tenant today
+func (q *Quota) Usage(tenant string) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.current(tenant).bytes
+}
+
+// Remaining returns budget left for the tenant today without bytes reserved by running runs;
+// false is returned for unlimited tenant
+func (q *Quota) Remaining(tenant string) (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.remaining(tenant)
+}
+
+// Reset forgets today's usage of the tenant and wakes deferred jobs
+func (q *Quota) Reset(tenant string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.usage, tenant)
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Allow checks budget of the tenant; with QuotaDefer policy it waits for the next day or Reset
+func (q *Quota) Allow(ctx context.Context, tenant string) error {
+	for {
+		q.mu.Lock()
+		remaining, limited := q.remaining(tenant)
+		changed := q.changed
+		now := q.options.Now()
+		q.mu.Unlock()
+
+		if !limited || remaining > 0 {
+			return nil
+		}
+		if q.options.Policy == QuotaReject {
+			return ErrQuotaExceeded
+		}
+
+		year, month, day := now.Date()
+		tomorrow := time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+		timer := time.NewTimer(tomorrow.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// RunCap returns byte cap of a run of the tenant: the smallest of runBytes,
+// RunBytes option and the remaining budget; zero is unlimited
+func (q *Quota) RunCap(tenant string, runBytes int64) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.runCap(tenant, runBytes)
+}
+
+func (q *Quota) runCap(tenant string, runBytes int64) int64 {
+	limit := runBytes
+	if q.options.RunBytes > 0 && (limit <= 0 || q.options.RunBytes < limit) {
+		limit = q.options.RunBytes
+	}
+	if remaining, limited := q.remaining(tenant); limited && (limit <= 0 || remaining < limit) {
+		// zero would mean unlimited, the exhausted budget stops the run at once
+		limit = remaining
+		if limit == 0 {
+			limit = 1
+		}
+	}
+	return limit
+}
+
+func (q *Quota) remaining(tenant string) (int64, bool) {
+	budget, ok := q.options.Tenants[tenant]
+	if !ok {
+		budget = q.options.DailyBytes
+	}
+	if budget <= 0 {
+		return 0, false
+	}
+
+	remaining := budget - q.current(tenant).bytes - q.reserved[tenant]
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// current returns usage of the tenant for today, the usage of previous days is dropped
+func (q *Quota) current(tenant string) tenantUsage {
+	day := q.options.Now().Format("2006-01-02")
+	usage := q.usage[tenant]
+	if usage.day != day {
+		usage = tenantUsage{day: day}
+	}
+	return usage
+}
+
+// SetByteCap stops rsync gracefully, like --stop-after does, when progress output shows
+// that the run transferred limit bytes; rsync should be started with Progress option.
+// Run returns ErrByteCapReached if rsync is stopped by the cap.
+func (t *Task) SetByteCap(limit int64) {
+	t.byteCap = limit
+}
+
+// progressBytesMatcher extracts transferred bytes of the current file from a progress record
+var progressBytesMatcher = regexp.MustCompile(`^\s*([\d.,]+[KMGTP]?)\s+\d+%`)
+
+// byteCounter sums transferred bytes from progress records separated by \r or \n
+type byteCounter struct {
+	limit   int64
+	onLimit func()
+
+	buffer  []byte
+	done    int64
+	current int64
+	reached bool
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.buffer = append(c.buffer, p...)
+	for {
+		index := bytes.IndexAny(c.buffer, "\r\n")
+		if index < 0 {
+			break
+		}
+		c.record(string(c.buffer[:index]))
+		c.buffer = c.buffer[index+1:]
+	}
+	return len(p), nil
+}
+
+func (c *byteCounter) record(record string) {
+	match := progressBytesMatcher.FindStringSubmatch(record)
+	if match == nil {
+		return
+	}
+
+	transferred := parseSize(match[1])
+	if transferred < c.current {
+		// the previous file ended without the final record
+		c.done += c.current
+	}
+	c.current = transferred
+	if strings.Contains(record, "xfr#") {
+		c.done += c.current
+		c.current = 0
+	}
+
+	if !c.reached && c.limit > 0 && c.total() >= c.limit {
+		c.reached = true
+		c.onLimit()
+	}
+}
+
+func (c *byteCounter) total() int64 {
+	return c.done + c.current
+}
+
+// readCloser reads through Reader and closes Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// stopGracefully asks rsync to stop, it keeps partial files on SIGTERM
+func (t *Task) stopGracefully() {
+	process := t.rsync.cmd.Process
+	if process == nil {
+		return
+	}
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		_ = process.Kill()
+	}
+}
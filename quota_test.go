@@ -0,0 +1,129 @@
+package grsync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	now := time.Date(2022, 3, 1, 23, 0, 0, 0, time.UTC)
+	quota := NewQuota(QuotaOptions{
+		DailyBytes: 1000,
+		Tenants:    map[string]int64{"big": 5000, "free": 0},
+		RunBytes:   800,
+		Now: func() time.Time {
+			return now
+		},
+	})
+
+	quota.Account("team", Stats{BytesSent: 300, BytesReceived: 100})
+	assert.Equal(t, int64(400), quota.Usage("team"))
+	remaining, limited := quota.Remaining("team")
+	assert.True(t, limited)
+	assert.Equal(t, int64(600), remaining)
+	assert.Equal(t, int64(600), quota.RunCap("team", 0))
+	assert.Equal(t, int64(500), quota.RunCap("team", 500))
+	assert.Equal(t, int64(800), quota.RunCap("big", 0))
+
+	_, limited = quota.Remaining("free")
+	assert.False(t, limited)
+
+	quota.Account("team", Stats{BytesSent: 700})
+	assert.Equal(t, ErrQuotaExceeded, quota.Allow(context.Background(), "team"))
+	assert.Equal(t, int64(1), quota.RunCap("team", 0))
+	assert.NoError(t, quota.Allow(context.Background(), "big"))
+
+	// the budget is renewed the next day
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, int64(0), quota.Usage("team"))
+	assert.NoError(t, quota.Allow(context.Background(), "team"))
+}
+
+func TestQuotaReserve(t *testing.T) {
+	quota := NewQuota(QuotaOptions{DailyBytes: 1000})
+
+	first := quota.Reserve("team", 0)
+	assert.Equal(t, int64(1000), first)
+	// a concurrent run doesn't get the reserved budget
+	assert.Equal(t, ErrQuotaExceeded, quota.Allow(context.Background(), "team"))
+	second := quota.Reserve("team", 0)
+	assert.Equal(t, int64(1), second)
+
+	// the run stopped by the cap has no stats, its progress is charged
+	quota.Settle("team", first, Stats{ProgressBytes: 400, BytesSent: 100})
+	quota.Settle("team", second, Stats{})
+	assert.Equal(t, int64(400), quota.Usage("team"))
+	remaining, _ := quota.Remaining("team")
+	assert.Equal(t, int64(600), remaining)
+}
+
+func TestQuotaDefer(t *testing.T) {
+	quota := NewQuota(QuotaOptions{DailyBytes: 100, Policy: QuotaDefer})
+	quota.Account("team", Stats{BytesReceived: 100})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, quota.Allow(ctx, "team"))
+
+	allowed := make(chan error)
+	go func() {
+		allowed <- quota.Allow(context.Background(), "team")
+	}()
+	select {
+	case <-allowed:
+		t.Fatal("job over budget is not deferred")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	quota.Reset("team")
+	assert.NoError(t, <-allowed)
+}
+
+func TestTaskByteCap(t *testing.T) {
+	binary := writeFakeRsync(t, `printf '        500  50%%  1.00MB/s    0:00:01\r'
+printf '      1,000 100%%  1.00MB/s    0:00:00 (xfr#1, to-chk=2/3)\n'
+printf '        800  40%%  1.00MB/s    0:00:01\r'
+printf '      1,500  75%%  1.00MB/s    0:00:01\r'
+exec sleep 10
+`)
+
+	task := NewTask([]string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary})
+	task.SetByteCap(2000)
+	started := time.Now()
+	assert.Equal(t, ErrByteCapReached, task.Run())
+	assert.Less(t, time.Since(started), 5*time.Second)
+	assert.Equal(t, int64(2500), task.Stats().ProgressBytes)
+}
+
+func TestSchedulerQuota(t *testing.T) {
+	quota := NewQuota(QuotaOptions{DailyBytes: 1000})
+	var executed []Job
+	scheduler := NewScheduler(SchedulerOptions{
+		Quota: quota,
+		Executor: func(ctx context.Context, job Job) (Stats, error) {
+			executed = append(executed, job)
+			return Stats{BytesSent: 700}, nil
+		},
+	})
+	defer func() {
+		_ = scheduler.Close()
+	}()
+
+	job := Job{Source: []string{"src/"}, Destination: "dst", Tenant: "team"}
+	_, err := scheduler.Submit(job).Wait()
+	require.NoError(t, err)
+	_, err = scheduler.Submit(job).Wait()
+	require.NoError(t, err)
+	_, err = scheduler.Submit(job).Wait()
+	assert.Equal(t, ErrQuotaExceeded, err)
+
+	require.Len(t, executed, 2)
+	assert.True(t, executed[0].Options.Stats)
+	assert.Equal(t, int64(1000), executed[0].MaxBytes)
+	assert.Equal(t, int64(300), executed[1].MaxBytes)
+	assert.Equal(t, int64(1400), quota.Usage("team"))
+}
@@ -0,0 +1,228 @@
+package grsync
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrLocalEndpoint is returned by RemoteTask when one of endpoints is not remote
+var ErrLocalEndpoint = errors.New("rsync: remote to remote transfer requires remote endpoints")
+
+// RelayOptions for RemoteToRemote
+type RelayOptions struct {
+	// SourceRsyncPath is a path to the rsync binary on the source host; by default just `rsync`
+	SourceRsyncPath string
+	// ForwardAgent forwards local ssh agent to the source host
+	ForwardAgent bool
+	// IdentityFile is a key on the source host used to connect to the destination host
+	IdentityFile string
+	// Relay always copies through StagingDir on the local machine
+	Relay bool
+	// Fallback copies through StagingDir when direct transfer fails
+	Fallback bool
+	// StagingDir is a local directory for relay; by default a temporary one removed after run
+	StagingDir string
+}
+
+// RemoteTask copies files between two remote hosts
+type RemoteTask struct {
+	source       Endpoint
+	destination  Endpoint
+	rsyncOptions RsyncOptions
+	options      RelayOptions
+
+	mu      sync.Mutex
+	task    *Task
+	relayed bool
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// RemoteToRemote returns task which runs rsync on the source host targeting the destination host
+func RemoteToRemote(source, destination Endpoint, rsyncOptions RsyncOptions, options RelayOptions) *RemoteTask {
+	return &RemoteTask{
+		source:       source,
+		destination:  destination,
+		rsyncOptions: rsyncOptions,
+		options:      options,
+		task:         NewTask(nil, "", rsyncOptions),
+		stdout:       io.Discard,
+		stderr:       io.Discard,
+	}
+}
+
+func (r *RemoteTask) SetStdout(stdout io.Writer) {
+	r.stdout = stdout
+}
+
+func (r *RemoteTask) SetStderr(stderr io.Writer) {
+	r.stderr = stderr
+}
+
+// State returns information about the current rsync process
+func (r *RemoteTask) State() State {
+	return r.currentTask().State()
+}
+
+// Log returns outputs of the current rsync process
+func (r *RemoteTask) Log() Log {
+	return r.currentTask().Log()
+}
+
+// Stats returns transfer statistics of the current rsync process
+func (r *RemoteTask) Stats() Stats {
+	return r.currentTask().Stats()
+}
+
+// Relayed reports whether files were copied through the local staging directory
+func (r *RemoteTask) Relayed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.relayed
+}
+
+// Run copies files directly or through the local relay
+func (r *RemoteTask) Run() error {
+	if r.source.IsLocal() || r.destination.IsLocal() {
+		return ErrLocalEndpoint
+	}
+
+	if !r.options.Relay {
+		err := r.runTask(r.directTask())
+		if err == nil || !r.options.Fallback || r.cancelled() {
+			return err
+		}
+	}
+
+	return r.relay()
+}
+
+// directTask runs rsync on the source host through the remote shell
+func (r *RemoteTask) directTask() *Task {
+	rsh := r.rsyncOptions.Rsh
+	if rsh == "" {
+		rsh = defaultRsh
+	}
+	arguments := strings.Fields(rsh)
+	binaryPath, arguments := arguments[0], arguments[1:]
+	if r.options.ForwardAgent {
+		arguments = append(arguments, "-A")
+	}
+	if r.source.Port > 0 {
+		arguments = append(arguments, "-p", strconv.Itoa(r.source.Port))
+	}
+	arguments = append(arguments, r.source.Address())
+
+	return newTask(r.rsyncOptions, func(rsyncOptions RsyncOptions) *Rsync {
+		command := append(append([]string{}, arguments...), r.remoteCommand(rsyncOptions))
+		rsync := newRsync([]string{r.source.String()}, "", newCommand(binaryPath, command, rsyncOptions), rsyncOptions)
+		if rsyncOptions.SSHPassword != "" {
+			// the remote command reads the password for the next hop, so it isn't on any command line
+			rsync.cmd.Stdin = strings.NewReader(rsyncOptions.SSHPassword + "\n")
+		}
+		return rsync
+	})
+}
+
+// remoteCommand returns rsync command line executed on the source host
+func (r *RemoteTask) remoteCommand(rsyncOptions RsyncOptions) string {
+	binaryPath := "rsync"
+	if r.options.SourceRsyncPath != "" {
+		binaryPath = r.options.SourceRsyncPath
+	}
+
+	// the command runs on the source host, where local keys, sockets and files don't exist,
+	// so its remote shell is built from RelayOptions only
+	options := forceOptions(rsyncOptions)
+	options.Rsh = defaultRsh
+	if r.options.IdentityFile != "" {
+		options.Rsh += " -i " + r.options.IdentityFile
+	}
+	options.PasswordFile = ""
+	options.RsyncPath = ""
+	options.RunAs = nil
+	options = r.destination.Options(options)
+
+	arguments := append([]string{binaryPath}, getArguments(options)...)
+	arguments = append(arguments, r.source.Path, r.destination.String())
+	for i, argument := range arguments {
+		arguments[i] = shellQuote(argument)
+	}
+
+	command := strings.Join(arguments, " ")
+	if rsyncOptions.SSHPassword != "" {
+		command = "read -r SSHPASS && export SSHPASS && exec sshpass -e " + command
+	}
+	return command
+}
+
+// relay copies files from the source host into the staging directory and then to the destination host
+func (r *RemoteTask) relay() error {
+	r.mu.Lock()
+	r.relayed = true
+	r.mu.Unlock()
+
+	staging := r.options.StagingDir
+	if staging == "" {
+		dir, err := os.MkdirTemp("", "grsync-relay-")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = os.RemoveAll(dir)
+		}()
+		staging = dir
+	}
+
+	download := NewTask([]string{r.source.String()}, staging+"/", r.source.Options(r.rsyncOptions))
+	if err := r.runTask(download); err != nil {
+		return err
+	}
+
+	// keep the meaning of trailing slash in the source path
+	stagedSource := staging + "/"
+	if !strings.HasSuffix(r.source.Path, "/") {
+		stagedSource = filepath.Join(staging, path.Base(r.source.Path))
+	}
+	upload := NewTask([]string{stagedSource}, r.destination.String(), r.destination.Options(r.rsyncOptions))
+
+	return r.runTask(upload)
+}
+
+func (r *RemoteTask) runTask(task *Task) error {
+	task.SetStdout(r.stdout)
+	task.SetStderr(r.stderr)
+
+	r.mu.Lock()
+	r.task = task
+	r.mu.Unlock()
+
+	return task.Run()
+}
+
+func (r *RemoteTask) cancelled() bool {
+	return r.rsyncOptions.RsyncContext != nil && r.rsyncOptions.RsyncContext.Err() != nil
+}
+
+func (r *RemoteTask) currentTask() *Task {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.task
+}
+
+// shellQuote quotes argument for POSIX shell
+func shellQuote(argument string) string {
+	if argument != "" && strings.IndexFunc(argument, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./-_", r))
+	}) < 0 {
+		return argument
+	}
+	return "'" + strings.ReplaceAll(argument, "'", `'\''`) + "'"
+}
@@ -0,0 +1,144 @@
+package grsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSSH runs the remote command locally, hosts named `unreachable` refuse connections
+const fakeSSH = `echo "$@" >> "$(dirname "$0")/calls"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-p|-i|-o) shift 2 ;;
+	-*) shift ;;
+	*) break ;;
+	esac
+done
+host=$1
+shift
+case "$host" in *unreachable) exit 255 ;; esac
+exec sh -c "$*"
+`
+
+// recordingRsync writes every argument on its own line
+const recordingRsync = `for arg; do echo "$arg"; done >> "$(dirname "$0")/calls"
+echo --- >> "$(dirname "$0")/calls"
+`
+
+func readCalls(t *testing.T, binary string) []string {
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(binary), "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "---\n"), "---\n")
+}
+
+func TestRemoteToRemote(t *testing.T) {
+	source := Endpoint{User: "usera", Host: "hostA", Path: "/src/"}
+	destination := Endpoint{User: "userb", Host: "hostB", Port: 2222, Path: "/dst"}
+
+	t.Run("direct", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeSSH)
+		rsync := writeFakeRsync(t, recordingRsync)
+
+		task := RemoteToRemote(source, destination, RsyncOptions{
+			Rsh:          ssh + " -i /local/key -o ControlPath=/local/socket",
+			PasswordFile: "/local/password",
+			RsyncPath:    "/local/rsync",
+			Delete:       true,
+		}, RelayOptions{
+			SourceRsyncPath: rsync,
+			ForwardAgent:    true,
+			IdentityFile:    "/keys/b",
+		})
+		assert.NoError(t, task.Run())
+		assert.False(t, task.Relayed())
+
+		sshCalls := readCalls(t, ssh)
+		assert.Len(t, sshCalls, 1)
+		assert.True(t, strings.HasPrefix(sshCalls[0], "-i /local/key -o ControlPath=/local/socket -A usera@hostA "+rsync))
+
+		rsyncCalls := readCalls(t, rsync)
+		assert.Len(t, rsyncCalls, 1)
+		args := strings.Split(strings.TrimSpace(rsyncCalls[0]), "\n")
+		assert.Contains(t, args, "--delete")
+		assert.Contains(t, args, "--partial")
+		assert.Contains(t, args, "ssh -i /keys/b -p 2222")
+		assert.NotContains(t, rsyncCalls[0], "/local/")
+		assert.Equal(t, []string{"/src/", "userb@hostB:/dst"}, args[len(args)-2:])
+	})
+
+	t.Run("password", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeSSH)
+		rsync := writeFakeRsync(t, recordingRsync)
+		sshpass := writeFakeRsync(t, fakeSSHPass)
+		// sshpass on the source host takes the password from the environment
+		remoteSSHPass := filepath.Join(t.TempDir(), "sshpass")
+		assert.NoError(t, os.WriteFile(remoteSSHPass, []byte(`#!/bin/sh
+echo "$SSHPASS" > "$(dirname "$0")/password"
+shift
+exec "$@"
+`), 0755))
+		t.Setenv("PATH", filepath.Dir(remoteSSHPass)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		task := RemoteToRemote(source, destination, RsyncOptions{Rsh: ssh, SSHPassword: "secret", SSHPassBinaryPath: sshpass},
+			RelayOptions{SourceRsyncPath: rsync})
+		assert.NoError(t, task.Run())
+
+		for _, binary := range []string{sshpass, remoteSSHPass} {
+			password, err := os.ReadFile(filepath.Join(filepath.Dir(binary), "password"))
+			assert.NoError(t, err)
+			assert.Equal(t, "secret\n", string(password))
+		}
+		assert.NotContains(t, readCalls(t, ssh)[0], "secret")
+		assert.Len(t, readCalls(t, rsync), 1)
+	})
+
+	t.Run("relay fallback", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeSSH)
+		rsync := writeFakeRsync(t, recordingRsync)
+		staging := t.TempDir()
+		unreachable := source
+		unreachable.Host = "unreachable"
+
+		task := RemoteToRemote(unreachable, destination, RsyncOptions{Rsh: ssh, RsyncBinaryPath: rsync}, RelayOptions{
+			Fallback:   true,
+			StagingDir: staging,
+		})
+		assert.NoError(t, task.Run())
+		assert.True(t, task.Relayed())
+
+		calls := readCalls(t, rsync)
+		assert.Len(t, calls, 2)
+		download := strings.Split(strings.TrimSpace(calls[0]), "\n")
+		assert.Equal(t, []string{"usera@unreachable:/src/", staging + "/"}, download[len(download)-2:])
+		upload := strings.Split(strings.TrimSpace(calls[1]), "\n")
+		assert.Equal(t, []string{staging + "/", "userb@hostB:/dst"}, upload[len(upload)-2:])
+		assert.Contains(t, upload, ssh+" -p 2222")
+	})
+
+	t.Run("direct failure without fallback", func(t *testing.T) {
+		ssh := writeFakeRsync(t, fakeSSH)
+		unreachable := source
+		unreachable.Host = "unreachable"
+
+		err := RemoteToRemote(unreachable, destination, RsyncOptions{Rsh: ssh}, RelayOptions{}).Run()
+		assert.True(t, IsRetryable(err))
+	})
+
+	t.Run("local endpoint", func(t *testing.T) {
+		err := RemoteToRemote(Endpoint{Path: "/src"}, destination, RsyncOptions{}, RelayOptions{}).Run()
+		assert.Equal(t, ErrLocalEndpoint, err)
+	})
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "/src/dir", shellQuote("/src/dir"))
+	assert.Equal(t, "''", shellQuote(""))
+	assert.Equal(t, "'ssh -i key'", shellQuote("ssh -i key"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+}
@@ -16,6 +16,7 @@ type Rsync struct {
 	Destination string
 
 	cmd *exec.Cmd
+	err error
 }
 
 // RsyncOptions for rsync
@@ -24,8 +25,9 @@ type RsyncOptions struct {
 	RsyncBinaryPath string
 	// SSHPassBinaryPath is a path to the sshpass binary; by default just `sshpass`
 	SSHPassBinaryPath string
-	// SSHPassword is a pass used with sshpass; by default `` - not used
-	SSHPassword string
+	// SSHPassword is a pass used with sshpass; by default `` - not used.
+	// It's never serialized, so persisted jobs, detached states and plans don't keep it
+	SSHPassword string `json:"-"`
 	// RsyncContext - context for exec
 	RsyncContext context.Context
 	// RsyncPath specify the rsync to run on remote machine, e.g `--rsync-path="cd /a/b && rsync"`
@@ -144,6 +146,10 @@ type RsyncOptions struct {
 	PartialDir string
 	// DelayUpdates put all updated files into place at end
 	DelayUpdates bool
+	// Fsync fsync every written file, since rsync 3.2.4
+	Fsync bool
+	// Preallocate allocate dest files before writing them
+	Preallocate bool
 	// PruneEmptyDirs prune empty directory chains from file-list
 	PruneEmptyDirs bool
 	// NumericIDs don't map uid/gid values by user/group name
@@ -152,6 +158,8 @@ type RsyncOptions struct {
 	Timeout int
 	// Contimeout=SECONDS set daemon connection timeout in seconds
 	Contimeout int
+	// StopAfter=MINS stop rsync after MINS minutes have elapsed
+	StopAfter int
 	// IgnoreTimes don't skip files that match size and time
 	IgnoreTimes bool
 	// SizeOnly skip files that match in size
@@ -168,6 +176,10 @@ type RsyncOptions struct {
 	CopyDest string
 	// LinkDest link-dest=DIR hardlink to files in DIR when unchanged
 	LinkDest string
+	// Backup make backups of changed and deleted files
+	Backup bool
+	// BackupDir backup-dir=DIR make backups into hierarchy based in DIR
+	BackupDir string
 	// Compress file data during the transfer
 	Compress bool
 	// CompressLevel explicitly set compression level
@@ -197,6 +209,9 @@ type RsyncOptions struct {
 	// Chown --chown="", chown on receipt.
 	Chown string
 
+	// ListOnly list the files instead of copying them
+	ListOnly bool
+
 	// ipv4
 	IPv4 bool
 	// ipv6
@@ -204,6 +219,13 @@ type RsyncOptions struct {
 
 	//out-format
 	OutFormat bool
+	// ItemizeChanges output a change-summary for all updates
+	ItemizeChanges bool
+
+	// RunAs runs rsync as a different local user; by default the current one
+	RunAs *RunAs
+	// Durability enables crash safety measures; see Durable
+	Durability *Durability
 }
 
 // StdoutPipe returns a pipe that will be connected to the command's
@@ -220,19 +242,28 @@ func (r Rsync) StderrPipe() (io.ReadCloser, error) {
 
 // Run start rsync task
 func (r Rsync) Run() error {
-	if !isExist(r.Destination) {
-		if err := createDir(r.Destination); err != nil {
-			return err
-		}
-	}
-
-	if err := r.cmd.Start(); err != nil {
+	if err := r.start(); err != nil {
 		return err
 	}
 
 	return r.cmd.Wait()
 }
 
+// start creates destination directory and starts rsync without waiting
+func (r Rsync) start() error {
+	if r.err != nil {
+		return r.err
+	}
+
+	if r.Destination != "" && !isRemotePath(r.Destination) && !isExist(r.Destination) {
+		if err := createDir(r.Destination, r.cmd); err != nil {
+			return err
+		}
+	}
+
+	return r.cmd.Start()
+}
+
 func PrintRsyncCommandForLinux(source []string, destination string, options RsyncOptions) (command string) {
 	var arguments []string
 	binaryPath := "rsync"
@@ -242,11 +273,11 @@ func PrintRsyncCommandForLinux(source []string, destination string, options Rsyn
 
 	if options.SSHPassword == "" {
 		arguments = append(getArguments(options), source...)
-		arguments = append(arguments, destination)
+		arguments = appendDestination(arguments, destination)
 	} else {
 		arguments = append([]string{"-p", "*****", binaryPath}, getArguments(options)...)
 		arguments = append(arguments, source...)
-		arguments = append(arguments, destination)
+		arguments = appendDestination(arguments, destination)
 		if options.SSHPassBinaryPath == "" {
 			binaryPath = "sshpass"
 		} else {
@@ -258,41 +289,52 @@ func PrintRsyncCommandForLinux(source []string, destination string, options Rsyn
 
 // NewRsync returns task with described options
 func NewRsync(source []string, destination string, options RsyncOptions) *Rsync {
-	var arguments []string
-
 	binaryPath := "rsync"
 	if options.RsyncBinaryPath != "" {
 		binaryPath = options.RsyncBinaryPath
 	}
-	var cmd *exec.Cmd
-	if options.SSHPassword == "" {
-		arguments = append(getArguments(options), source...)
-		arguments = append(arguments, destination)
-	} else {
-		arguments = append([]string{"-p", options.SSHPassword, binaryPath}, getArguments(options)...)
-		arguments = append(arguments, source...)
-		arguments = append(arguments, destination)
+
+	arguments := append(getArguments(options), source...)
+	arguments = appendDestination(arguments, destination)
+
+	return newRsync(source, destination, newCommand(binaryPath, arguments, options), options)
+}
+
+// newRsync returns rsync wrapper around cmd with process attributes from options
+func newRsync(source []string, destination string, cmd *exec.Cmd, options RsyncOptions) *Rsync {
+	var err error
+	if options.RunAs != nil {
+		err = applyRunAs(cmd, options.RunAs)
+	}
+
+	return &Rsync{
+		Source:      source,
+		Destination: destination,
+		cmd:         cmd,
+		err:         err,
+	}
+}
+
+// newCommand returns command which runs binary through sshpass if password is set
+func newCommand(binaryPath string, arguments []string, options RsyncOptions) *exec.Cmd {
+	if options.SSHPassword != "" {
+		arguments = append([]string{"-p", options.SSHPassword, binaryPath}, arguments...)
 		if options.SSHPassBinaryPath == "" {
 			binaryPath = "sshpass"
 		} else {
 			binaryPath = options.SSHPassBinaryPath
 		}
 	}
-	if options.RsyncContext == nil {
-		cmd = exec.Command(binaryPath, arguments...)
-	} else {
-		cmd = exec.CommandContext(options.RsyncContext, binaryPath, arguments...)
-	}
 
-	return &Rsync{
-		Source:      source,
-		Destination: destination,
-		cmd:         cmd,
+	if options.RsyncContext == nil {
+		return exec.Command(binaryPath, arguments...)
 	}
+	return exec.CommandContext(options.RsyncContext, binaryPath, arguments...)
 }
 
 func getArguments(options RsyncOptions) []string {
 	var arguments []string
+	options = options.Durability.apply(options)
 
 	if options.RsyncPath != "" {
 		arguments = append(arguments, "--rsync-path", options.RsyncPath)
@@ -522,6 +564,14 @@ func getArguments(options RsyncOptions) []string {
 		arguments = append(arguments, "--delay-updates")
 	}
 
+	if options.Fsync {
+		arguments = append(arguments, "--fsync")
+	}
+
+	if options.Preallocate {
+		arguments = append(arguments, "--preallocate")
+	}
+
 	if options.PruneEmptyDirs {
 		arguments = append(arguments, "--prune-empty-dirs")
 	}
@@ -538,6 +588,10 @@ func getArguments(options RsyncOptions) []string {
 		arguments = append(arguments, "--contimeout", strconv.Itoa(options.Contimeout))
 	}
 
+	if options.StopAfter > 0 {
+		arguments = append(arguments, "--stop-after", strconv.Itoa(options.StopAfter))
+	}
+
 	if options.IgnoreTimes {
 		arguments = append(arguments, "--ignore-times")
 	}
@@ -570,6 +624,14 @@ func getArguments(options RsyncOptions) []string {
 		arguments = append(arguments, "--link-dest", options.LinkDest)
 	}
 
+	if options.Backup {
+		arguments = append(arguments, "--backup")
+	}
+
+	if options.BackupDir != "" {
+		arguments = append(arguments, "--backup-dir", options.BackupDir)
+	}
+
 	if options.Compress {
 		arguments = append(arguments, "--compress")
 	}
@@ -606,6 +668,10 @@ func getArguments(options RsyncOptions) []string {
 		arguments = append(arguments, "--bwlimit", strconv.Itoa(options.BandwidthLimit))
 	}
 
+	if options.ListOnly {
+		arguments = append(arguments, "--list-only")
+	}
+
 	if options.IPv4 {
 		arguments = append(arguments, "--ipv4")
 	}
@@ -622,6 +688,10 @@ func getArguments(options RsyncOptions) []string {
 		arguments = append(arguments, "--out-format=\"%n\"")
 	}
 
+	if options.ItemizeChanges {
+		arguments = append(arguments, "--itemize-changes")
+	}
+
 	if len(options.Include) > 0 {
 		for _, pattern := range options.Include {
 			arguments = append(arguments, fmt.Sprintf("--include=%s", pattern))
@@ -645,8 +715,19 @@ func getArguments(options RsyncOptions) []string {
 	return arguments
 }
 
-func createDir(dir string) error {
+// appendDestination adds destination argument; empty destination is omitted, e.g. for --list-only
+func appendDestination(arguments []string, destination string) []string {
+	if destination == "" {
+		return arguments
+	}
+	return append(arguments, destination)
+}
+
+// createDir creates directory with credentials and environment of rsync command, e.g. as RunAs user
+func createDir(dir string, rsync *exec.Cmd) error {
 	cmd := exec.Command("mkdir", "-p", dir)
+	cmd.SysProcAttr = rsync.SysProcAttr
+	cmd.Env = rsync.Env
 	if err := cmd.Start(); err != nil {
 		return err
 	}
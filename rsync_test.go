@@ -426,6 +426,27 @@ func TestParseArguments(t *testing.T) {
 		assert.ElementsMatch(t, args, []string{"--contimeout", "100"})
 	})
 
+	t.Run("--fsync", func(t *testing.T) {
+		args := getArguments(RsyncOptions{
+			Fsync: true,
+		})
+		assert.Contains(t, args, "--fsync")
+	})
+
+	t.Run("--preallocate", func(t *testing.T) {
+		args := getArguments(RsyncOptions{
+			Preallocate: true,
+		})
+		assert.Contains(t, args, "--preallocate")
+	})
+
+	t.Run("--stop-after", func(t *testing.T) {
+		args := getArguments(RsyncOptions{
+			StopAfter: 30,
+		})
+		assert.ElementsMatch(t, args, []string{"--stop-after", "30"})
+	})
+
 	t.Run("--ignore-times", func(t *testing.T) {
 		args := getArguments(RsyncOptions{
 			IgnoreTimes: true,
@@ -482,6 +503,20 @@ func TestParseArguments(t *testing.T) {
 		assert.Contains(t, args, "--link-dest", "test")
 	})
 
+	t.Run("--backup", func(t *testing.T) {
+		args := getArguments(RsyncOptions{
+			Backup: true,
+		})
+		assert.Contains(t, args, "--backup")
+	})
+
+	t.Run("--backup-dir", func(t *testing.T) {
+		args := getArguments(RsyncOptions{
+			BackupDir: "test",
+		})
+		assert.ElementsMatch(t, args, []string{"--backup-dir", "test"})
+	})
+
 	t.Run("--compress", func(t *testing.T) {
 		args := getArguments(RsyncOptions{
 			Compress: true,
@@ -571,6 +606,20 @@ func TestParseArguments(t *testing.T) {
 		assert.Contains(t, args, "--chown=nobody:nobody")
 	})
 
+	t.Run("--list-only", func(t *testing.T) {
+		args := getArguments(RsyncOptions{
+			ListOnly: true,
+		})
+		assert.Contains(t, args, "--list-only")
+	})
+
+	t.Run("--itemize-changes", func(t *testing.T) {
+		args := getArguments(RsyncOptions{
+			ItemizeChanges: true,
+		})
+		assert.Contains(t, args, "--itemize-changes")
+	})
+
 	t.Run("--ipv4", func(t *testing.T) {
 		args := getArguments(RsyncOptions{
 			IPv4: true,
@@ -631,7 +680,7 @@ func TestSyncStatus(t *testing.T) {
 	}
 
 	task = NewTask(
-		testFileForSync,
+		[]string{testFileForSync},
 		targetCopy,
 		options,
 	)
@@ -671,3 +720,17 @@ func init() {
 	}
 	zap.ReplaceGlobals(logger)
 }
+
+func TestRsyncRemoteDestinationNotCreated(t *testing.T) {
+	binary := writeFakeRsync(t, "exit 0")
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+
+	assert.NoError(t, NewRsync([]string{"src/"}, "host:/backup", RsyncOptions{RsyncBinaryPath: binary}).Run())
+	assert.NoDirExists(t, "host:")
+}
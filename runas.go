@@ -0,0 +1,93 @@
+package grsync
+
+import (
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// RunAs describes a local user the rsync process runs as
+type RunAs struct {
+	// Username sets USER and LOGNAME variables
+	Username string
+	UID      uint32
+	GID      uint32
+	// Groups are supplementary groups; by default none
+	Groups []uint32
+	// HomeDir sets HOME variable, so ssh finds the user's config and keys
+	HomeDir string
+	// SSHAuthSock is the user's agent socket; by default SSH_AUTH_SOCK is removed
+	SSHAuthSock string
+}
+
+// LookupRunAs returns RunAs for the user with the given name or uid
+func LookupRunAs(username string) (*RunAs, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		if u, err = user.LookupId(username); err != nil {
+			return nil, err
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		group, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, uint32(group))
+	}
+
+	return &RunAs{
+		Username: u.Username,
+		UID:      uint32(uid),
+		GID:      uint32(gid),
+		Groups:   groups,
+		HomeDir:  u.HomeDir,
+	}, nil
+}
+
+// environment returns env with variables of the current user replaced by variables of RunAs user
+func (r RunAs) environment(env []string) []string {
+	replaced := map[string]string{
+		"SSH_AUTH_SOCK": r.SSHAuthSock,
+	}
+	if r.HomeDir != "" {
+		replaced["HOME"] = r.HomeDir
+	}
+	if r.Username != "" {
+		replaced["USER"] = r.Username
+		replaced["LOGNAME"] = r.Username
+	}
+
+	result := make([]string, 0, len(env)+len(replaced))
+	for _, variable := range env {
+		name := variable
+		if i := strings.IndexByte(variable, '='); i >= 0 {
+			name = variable[:i]
+		}
+		if _, ok := replaced[name]; !ok {
+			result = append(result, variable)
+		}
+	}
+	for name, value := range replaced {
+		if value != "" {
+			result = append(result, name+"="+value)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9 || js || wasip1
+// +build windows plan9 js wasip1
+
+package grsync
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func applyRunAs(cmd *exec.Cmd, runAs *RunAs) error {
+	return errors.New("rsync: RunAs is not supported on this platform")
+}
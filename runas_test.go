@@ -0,0 +1,83 @@
+package grsync
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupRunAs(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	t.Run("by name", func(t *testing.T) {
+		runAs, err := LookupRunAs(current.Username)
+		assert.NoError(t, err)
+		assert.Equal(t, current.Username, runAs.Username)
+		assert.Equal(t, current.HomeDir, runAs.HomeDir)
+		assert.Equal(t, uint32(os.Getuid()), runAs.UID)
+		assert.Equal(t, uint32(os.Getgid()), runAs.GID)
+	})
+
+	t.Run("by uid", func(t *testing.T) {
+		runAs, err := LookupRunAs(current.Uid)
+		assert.NoError(t, err)
+		assert.Equal(t, current.Username, runAs.Username)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		_, err := LookupRunAs("grsync-unknown-user")
+		assert.Error(t, err)
+	})
+}
+
+func TestRunAsEnvironment(t *testing.T) {
+	env := RunAs{Username: "backup", HomeDir: "/home/backup"}.environment([]string{
+		"HOME=/root",
+		"USER=root",
+		"PATH=/usr/bin",
+		"SSH_AUTH_SOCK=/tmp/agent",
+	})
+	assert.ElementsMatch(t, []string{"PATH=/usr/bin", "HOME=/home/backup", "USER=backup", "LOGNAME=backup"}, env)
+
+	env = RunAs{SSHAuthSock: "/run/backup/agent"}.environment([]string{"HOME=/root", "SSH_AUTH_SOCK=/tmp/agent"})
+	assert.ElementsMatch(t, []string{"HOME=/root", "SSH_AUTH_SOCK=/run/backup/agent"}, env)
+}
+
+func TestRunAs(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching user requires root")
+	}
+	nobody, err := LookupRunAs("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+	assert.NoError(t, os.Chmod(dir, 0777))
+	binary := filepath.Join(dir, "rsync")
+	script := "#!/bin/sh\necho \"$(id -u) $HOME ${SSH_AUTH_SOCK:-none}\"\n"
+	assert.NoError(t, os.WriteFile(binary, []byte(script), 0755))
+	assert.NoError(t, os.Chmod(filepath.Dir(dir), 0755))
+
+	task := NewTask([]string{"a"}, dir, RsyncOptions{RsyncBinaryPath: binary, RunAs: nobody})
+	assert.NoError(t, task.Run())
+	assert.Equal(t, fmt.Sprintf("%d %s none", nobody.UID, nobody.HomeDir), strings.TrimSpace(task.Log().Stdout))
+
+	t.Run("destination created as the user", func(t *testing.T) {
+		destination := filepath.Join(dir, "backup")
+		task := NewTask([]string{"a"}, destination, RsyncOptions{RsyncBinaryPath: binary, RunAs: nobody})
+		assert.NoError(t, task.Run())
+
+		info, err := os.Stat(destination)
+		assert.NoError(t, err)
+		assert.Equal(t, nobody.UID, inodeOf(info).uid)
+	})
+}
@@ -0,0 +1,24 @@
+//go:build !windows && !plan9 && !js && !wasip1
+// +build !windows,!plan9,!js,!wasip1
+
+package grsync
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func applyRunAs(cmd *exec.Cmd, runAs *RunAs) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    runAs.UID,
+		Gid:    runAs.GID,
+		Groups: runAs.Groups,
+	}
+	cmd.Env = runAs.environment(os.Environ())
+
+	return nil
+}
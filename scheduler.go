@@ -0,0 +1,407 @@
+package grsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSchedulerClosed is returned for jobs submitted to closed Scheduler
+var ErrSchedulerClosed = errors.New("rsync: scheduler is closed")
+
+// Job is a sync requested from Scheduler
+type Job struct {
+	Name        string
+	Source      []string
+	Destination string
+	Options     RsyncOptions
+	// Tenant is a team the job is accounted to by Quota
+	Tenant string
+	// MaxBytes caps bytes transferred by a run; zero is unlimited
+	MaxBytes int64
+}
+
+// Fingerprint identifies jobs doing the same sync: source, destination and options; the name counts
+// only when paths depend on it through templates. The password isn't hashed, so it doesn't leak.
+func (j Job) Fingerprint() (string, error) {
+	if !j.templatesUseName() {
+		j.Name = ""
+	}
+	j.Options.RsyncContext = nil
+	j.Options.SSHPassword = ""
+	data, err := json.Marshal(j)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Executor runs a job; ctx is done when the scheduler is closed
+type Executor func(ctx context.Context, job Job) (Stats, error)
+
+// RunJob is the default Executor which runs the job as a Task
+func RunJob(ctx context.Context, job Job) (Stats, error) {
+	job.Options.RsyncContext = ctx
+	task := NewTask(job.Source, job.Destination, job.Options)
+	task.SetByteCap(job.MaxBytes)
+	err := task.Run()
+	return task.Stats(), err
+}
+
+// SchedulerOptions for Scheduler
+type SchedulerOptions struct {
+	// Executor runs jobs; by default RunJob
+	Executor Executor
+	// Concurrency limits jobs running at once; by default 1
+	Concurrency int
+	// Store persists queued and running jobs, they are resumed by Recover
+	Store JobStore
+	// RequeueInterrupted resubmits jobs interrupted by a stop on Recover
+	RequeueInterrupted bool
+	// PartialDir is set for requeued jobs, so partially transferred files are reused
+	PartialDir string
+	// Quota rejects or defers jobs of tenants over budget and caps their runs
+	Quota *Quota
+	// History provides {{previous}} of job templates; by default Store if it's RunHistory
+	History RunHistory
+	// Root is a directory the expanded destination side paths of jobs must stay under
+	Root string
+}
+
+// Scheduler runs submitted jobs. Triggers of a job already running collapse
+// into at most one pending rerun, so waiters get the result of the run covering their request.
+type Scheduler struct {
+	options SchedulerOptions
+	ctx     context.Context
+	cancel  context.CancelFunc
+	slots   chan struct{}
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	jobs   map[string]*scheduledJob
+	closed bool
+}
+
+// scheduledJob holds runs of the same fingerprint
+type scheduledJob struct {
+	current *jobRun
+	pending *jobRun
+}
+
+type jobRun struct {
+	id      string
+	created time.Time
+	job     Job
+	started bool
+	done    chan struct{}
+	stats   Stats
+	err     error
+}
+
+// Ticket is a handle of a submitted job
+type Ticket struct {
+	run *jobRun
+	// Coalesced reports whether the request joined a run triggered by another request
+	Coalesced bool
+}
+
+// ID returns identifier of the run covering the request
+func (t *Ticket) ID() string {
+	return t.run.id
+}
+
+// Done is closed when the run covering the request is finished
+func (t *Ticket) Done() <-chan struct{} {
+	return t.run.done
+}
+
+// Wait waits for the run covering the request and returns its result
+func (t *Ticket) Wait() (Stats, error) {
+	<-t.run.done
+	return t.run.stats, t.run.err
+}
+
+// NewScheduler returns started scheduler
+func NewScheduler(options SchedulerOptions) *Scheduler {
+	if options.Executor == nil {
+		options.Executor = RunJob
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	if history, ok := options.Store.(RunHistory); ok && options.History == nil {
+		options.History = history
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		options: options,
+		ctx:     ctx,
+		cancel:  cancel,
+		slots:   make(chan struct{}, options.Concurrency),
+		jobs:    map[string]*scheduledJob{},
+	}
+}
+
+// Submit schedules the job. A job with the same fingerprint that has not started yet
+// covers the request, otherwise one rerun is queued after the current run.
+func (s *Scheduler) Submit(job Job) *Ticket {
+	return s.submit(JobRecord{ID: newJobID(), Job: job, CreatedAt: time.Now()})
+}
+
+// Recover resumes jobs from the store: queued jobs are submitted again, running ones
+// are marked interrupted and submitted again with partial transfer if RequeueInterrupted is set
+func (s *Scheduler) Recover() ([]*Ticket, error) {
+	if s.options.Store == nil {
+		return nil, nil
+	}
+	records, err := s.options.Store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var tickets []*Ticket
+	for _, record := range records {
+		switch record.Status {
+		case JobRunning, JobInterrupted:
+			if record.Status == JobRunning {
+				record.Status = JobInterrupted
+				if err = s.save(record); err != nil {
+					return tickets, err
+				}
+			}
+			if !s.options.RequeueInterrupted {
+				continue
+			}
+			record.Job.Options.Partial = true
+			if s.options.PartialDir != "" && record.Job.Options.PartialDir == "" {
+				record.Job.Options.PartialDir = s.options.PartialDir
+			}
+		}
+		tickets = append(tickets, s.submit(record))
+	}
+
+	return tickets, nil
+}
+
+func (s *Scheduler) submit(record JobRecord) *Ticket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job := record.Job
+	if s.closed {
+		return failedTicket(record, ErrSchedulerClosed)
+	}
+
+	fingerprint, err := job.Fingerprint()
+	if err != nil {
+		return failedTicket(record, err)
+	}
+	scheduled, ok := s.jobs[fingerprint]
+	var run *jobRun
+	coalesced := true
+	switch {
+	case !ok:
+		run, coalesced = newJobRun(record), false
+		scheduled = &scheduledJob{current: run}
+	case !scheduled.current.started:
+		run = scheduled.current
+	case scheduled.pending != nil:
+		run = scheduled.pending
+		run.job = job
+	default:
+		run, coalesced = newJobRun(record), false
+		scheduled.pending = run
+	}
+
+	if err := s.persist(run, JobQueued); err != nil {
+		if !coalesced {
+			// the run isn't scheduled without its record
+			if scheduled.pending == run {
+				scheduled.pending = nil
+			}
+			return failedTicket(record, err)
+		}
+	}
+	if coalesced && record.ID != run.id {
+		_ = s.delete(record.ID)
+	}
+
+	if !ok {
+		s.jobs[fingerprint] = scheduled
+		s.wg.Add(1)
+		go s.run(fingerprint, scheduled)
+	}
+
+	return &Ticket{run: run, Coalesced: coalesced}
+}
+
+// failedTicket returns ticket of the job which isn't scheduled
+func failedTicket(record JobRecord, err error) *Ticket {
+	run := &jobRun{id: record.ID, job: record.Job, done: make(chan struct{}), err: err}
+	close(run.done)
+	return &Ticket{run: run}
+}
+
+// Close cancels running jobs and waits for them
+func (s *Scheduler) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) run(fingerprint string, scheduled *scheduledJob) {
+	defer s.wg.Done()
+
+	for {
+		s.mu.Lock()
+		run := scheduled.current
+		s.mu.Unlock()
+
+		run.stats, run.err = s.execute(run)
+		close(run.done)
+
+		s.mu.Lock()
+		if scheduled.pending == nil {
+			delete(s.jobs, fingerprint)
+			s.mu.Unlock()
+			return
+		}
+		scheduled.current, scheduled.pending = scheduled.pending, nil
+		s.mu.Unlock()
+	}
+}
+
+func (s *Scheduler) execute(run *jobRun) (Stats, error) {
+	if err := s.allow(run); err != nil {
+		return Stats{}, err
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-s.ctx.Done():
+		// the record stays queued
+		return Stats{}, ErrSchedulerClosed
+	}
+	defer func() {
+		<-s.slots
+	}()
+
+	s.mu.Lock()
+	if s.closed {
+		// select took the free slot though Close was called, the record stays queued
+		s.mu.Unlock()
+		return Stats{}, ErrSchedulerClosed
+	}
+	run.started = true
+	job := run.job
+	err := s.persist(run, JobRunning)
+	s.mu.Unlock()
+	if err == nil {
+		job, err = s.expand(job)
+	}
+	if err != nil {
+		s.mu.Lock()
+		_ = s.delete(run.id)
+		s.mu.Unlock()
+		return Stats{}, err
+	}
+
+	if s.options.Quota != nil {
+		job.Options.Stats = true
+		job.MaxBytes = s.options.Quota.Reserve(job.Tenant, job.MaxBytes)
+	}
+	stats, err := s.options.Executor(s.ctx, job)
+	if s.options.Quota != nil {
+		s.options.Quota.Settle(job.Tenant, job.MaxBytes, stats)
+	}
+	if err == nil && s.options.History != nil && job.Name != "" {
+		// {{previous}} is a path on the receiver, e.g. for LinkDest, so the host isn't kept
+		err = s.options.History.SetLastDestination(job.Name, localPart(job.Destination))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var storeErr error
+	if err != nil && s.ctx.Err() != nil {
+		storeErr = s.persist(run, JobInterrupted)
+	} else {
+		storeErr = s.delete(run.id)
+	}
+	if err == nil {
+		err = storeErr
+	}
+	return stats, err
+}
+
+// expand expands templates of the job with the previous run destination from History
+func (s *Scheduler) expand(job Job) (Job, error) {
+	vars := TemplateVars{Job: job.Name}
+	if s.options.History != nil && job.Name != "" {
+		previous, err := s.options.History.LastDestination(job.Name)
+		if err != nil {
+			return job, err
+		}
+		vars.Previous = previous
+	}
+	return job.Expand(vars, s.options.Root)
+}
+
+// allow checks quota of the job tenant before the run takes a slot
+func (s *Scheduler) allow(run *jobRun) error {
+	if s.options.Quota == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	tenant := run.job.Tenant
+	s.mu.Unlock()
+
+	err := s.options.Quota.Allow(s.ctx, tenant)
+	switch {
+	case err == nil:
+		return nil
+	case s.ctx.Err() != nil:
+		// the record stays queued
+		return ErrSchedulerClosed
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.delete(run.id)
+	return err
+}
+
+// persist saves the run record with the status
+func (s *Scheduler) persist(run *jobRun, status JobStatus) error {
+	return s.save(JobRecord{ID: run.id, Job: run.job, Status: status, CreatedAt: run.created})
+}
+
+func (s *Scheduler) save(record JobRecord) error {
+	if s.options.Store == nil {
+		return nil
+	}
+	record.UpdatedAt = time.Now()
+	record.Job.Options.RsyncContext = nil
+	return s.options.Store.Save(record)
+}
+
+func (s *Scheduler) delete(id string) error {
+	if s.options.Store == nil {
+		return nil
+	}
+	return s.options.Store.Delete(id)
+}
+
+func newJobRun(record JobRecord) *jobRun {
+	return &jobRun{id: record.ID, created: record.CreatedAt, job: record.Job, done: make(chan struct{})}
+}
@@ -0,0 +1,257 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingExecutor runs jobs one by one when release is called
+type blockingExecutor struct {
+	mu      sync.Mutex
+	runs    int
+	started chan string
+	release chan error
+}
+
+func newBlockingExecutor() *blockingExecutor {
+	return &blockingExecutor{started: make(chan string, 16), release: make(chan error)}
+}
+
+func (e *blockingExecutor) execute(ctx context.Context, job Job) (Stats, error) {
+	e.mu.Lock()
+	e.runs++
+	runs := e.runs
+	e.mu.Unlock()
+
+	e.started <- job.Name
+	select {
+	case err := <-e.release:
+		return Stats{Files: runs}, err
+	case <-ctx.Done():
+		return Stats{}, ctx.Err()
+	}
+}
+
+func TestJobFingerprint(t *testing.T) {
+	job := Job{Name: "a", Source: []string{"src/"}, Destination: "dst", Options: RsyncOptions{Delete: true}}
+
+	fingerprint := func(job Job) string {
+		fingerprint, err := job.Fingerprint()
+		assert.NoError(t, err)
+		return fingerprint
+	}
+
+	same := job
+	same.Name = "b"
+	same.Options.RsyncContext = context.Background()
+	same.Options.SSHPassword = "secret"
+	assert.Equal(t, fingerprint(job), fingerprint(same))
+
+	other := job
+	other.Options.Delete = false
+	assert.NotEqual(t, fingerprint(job), fingerprint(other))
+
+	// the name is a part of the destination
+	templated := job
+	templated.Destination = "/backups/{{host}}/{{job}}"
+	renamed := templated
+	renamed.Name = "b"
+	assert.NotEqual(t, fingerprint(templated), fingerprint(renamed))
+}
+
+func TestSchedulerCoalescing(t *testing.T) {
+	executor := newBlockingExecutor()
+	scheduler := NewScheduler(SchedulerOptions{Executor: executor.execute})
+	defer func() {
+		_ = scheduler.Close()
+	}()
+
+	job := Job{Source: []string{"src/"}, Destination: "dst"}
+	first := scheduler.Submit(job)
+	assert.False(t, first.Coalesced)
+	<-executor.started
+
+	// triggers during the run collapse into one rerun
+	second := scheduler.Submit(job)
+	assert.False(t, second.Coalesced)
+	third := scheduler.Submit(job)
+	assert.True(t, third.Coalesced)
+
+	executor.release <- nil
+	stats, err := first.Wait()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Files)
+
+	<-executor.started
+	failed := errors.New("failed")
+	executor.release <- failed
+	for _, ticket := range []*Ticket{second, third} {
+		stats, err = ticket.Wait()
+		assert.Equal(t, failed, err)
+		assert.Equal(t, 2, stats.Files)
+	}
+
+	// another fingerprint isn't coalesced
+	other := scheduler.Submit(Job{Source: []string{"other/"}, Destination: "dst"})
+	assert.False(t, other.Coalesced)
+	<-executor.started
+	executor.release <- nil
+	_, err = other.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, executor.runs)
+}
+
+func TestFileStorePassword(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	job := Job{Name: "a", Source: []string{"src/"}, Destination: "host:dst"}
+	job.Options.SSHPassword = "secret"
+	require.NoError(t, store.Save(JobRecord{ID: "1", Job: job}))
+
+	data, err := os.ReadFile(store.path("1"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+}
+
+func TestSchedulerQueued(t *testing.T) {
+	executor := newBlockingExecutor()
+	scheduler := NewScheduler(SchedulerOptions{Executor: executor.execute})
+
+	busy := scheduler.Submit(Job{Name: "busy", Destination: "busy"})
+	<-executor.started
+
+	// the job waits for a free slot, so new triggers are covered by it
+	queued := scheduler.Submit(Job{Name: "queued", Destination: "dst"})
+	again := scheduler.Submit(Job{Name: "queued", Destination: "dst"})
+	assert.True(t, again.Coalesced)
+
+	require.NoError(t, scheduler.Close())
+	for _, ticket := range []*Ticket{busy, queued, again} {
+		_, err := ticket.Wait()
+		assert.Error(t, err)
+	}
+
+	_, err := scheduler.Submit(Job{}).Wait()
+	assert.Equal(t, ErrSchedulerClosed, err)
+}
+
+func TestSchedulerClosedWithPendingRerun(t *testing.T) {
+	// the pending rerun finds a free slot and the cancelled context at once, select picks randomly
+	for i := 0; i < 10; i++ {
+		store, err := NewFileStore(t.TempDir())
+		require.NoError(t, err)
+		executor := newBlockingExecutor()
+		scheduler := NewScheduler(SchedulerOptions{Executor: executor.execute, Store: store, Concurrency: 2})
+
+		job := Job{Name: "job", Source: []string{"src/"}, Destination: "dst"}
+		scheduler.Submit(job)
+		<-executor.started
+		rerun := scheduler.Submit(job)
+		require.NoError(t, scheduler.Close())
+
+		_, err = rerun.Wait()
+		assert.Equal(t, ErrSchedulerClosed, err)
+		records, err := store.Load()
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, JobInterrupted, records[0].Status)
+		assert.Equal(t, JobQueued, records[1].Status)
+	}
+}
+
+func TestSchedulerRecover(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	// the first scheduler crashes while the first job is running and the second is queued
+	crashed := newBlockingExecutor()
+	scheduler := NewScheduler(SchedulerOptions{Executor: crashed.execute, Store: store})
+	t.Cleanup(func() {
+		_ = scheduler.Close()
+	})
+	running := scheduler.Submit(Job{Name: "running", Source: []string{"src/"}, Destination: "running"})
+	<-crashed.started
+	queued := scheduler.Submit(Job{Name: "queued", Source: []string{"src/"}, Destination: "queued"})
+
+	records, err := store.Load()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, JobRunning, records[0].Status)
+	assert.Equal(t, JobQueued, records[1].Status)
+
+	t.Run("requeue", func(t *testing.T) {
+		var mu sync.Mutex
+		executed := map[string]Job{}
+		scheduler := NewScheduler(SchedulerOptions{
+			Executor: func(ctx context.Context, job Job) (Stats, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				executed[job.Name] = job
+				return Stats{}, nil
+			},
+			Store:              copyStore(t, store),
+			RequeueInterrupted: true,
+			PartialDir:         ".partial",
+		})
+		defer func() {
+			_ = scheduler.Close()
+		}()
+
+		tickets, err := scheduler.Recover()
+		require.NoError(t, err)
+		require.Len(t, tickets, 2)
+		assert.Equal(t, running.ID(), tickets[0].ID())
+		assert.Equal(t, queued.ID(), tickets[1].ID())
+		for _, ticket := range tickets {
+			_, err = ticket.Wait()
+			assert.NoError(t, err)
+		}
+
+		assert.True(t, executed["running"].Options.Partial)
+		assert.Equal(t, ".partial", executed["running"].Options.PartialDir)
+		assert.False(t, executed["queued"].Options.Partial)
+
+		records, err := scheduler.options.Store.Load()
+		require.NoError(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("mark interrupted", func(t *testing.T) {
+		store := copyStore(t, store)
+		executor := newBlockingExecutor()
+		scheduler := NewScheduler(SchedulerOptions{Executor: executor.execute, Store: store})
+
+		tickets, err := scheduler.Recover()
+		require.NoError(t, err)
+		require.Len(t, tickets, 1)
+		assert.Equal(t, queued.ID(), tickets[0].ID())
+		<-executor.started
+
+		// graceful stop keeps the queue too
+		require.NoError(t, scheduler.Close())
+		records, err := store.Load()
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, JobInterrupted, records[0].Status)
+		assert.Equal(t, JobInterrupted, records[1].Status)
+	})
+}
+
+// copyStore copies records into a new store
+func copyStore(t *testing.T, store JobStore) *FileStore {
+	records, err := store.Load()
+	require.NoError(t, err)
+
+	copied, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	for _, record := range records {
+		require.NoError(t, copied.Save(record))
+	}
+	return copied
+}
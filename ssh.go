@@ -0,0 +1,63 @@
+package grsync
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SSHOptions describes ssh command used as rsync remote shell
+type SSHOptions struct {
+	// BinaryPath is a path to the ssh binary; by default just `ssh`
+	BinaryPath string
+	// Port is ssh port; by default the standard one
+	Port int
+	// IdentityFile is a private key used for authentication
+	IdentityFile string
+	// Options are passed as `-o`, e.g. `StrictHostKeyChecking=yes`
+	Options []string
+}
+
+// Rsh returns command for Rsh option
+func (o SSHOptions) Rsh() string {
+	binaryPath, arguments := o.command()
+
+	quoted := []string{shellQuote(binaryPath)}
+	for _, argument := range arguments {
+		quoted = append(quoted, shellQuote(argument))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// command returns ssh binary and its arguments
+func (o SSHOptions) command() (string, []string) {
+	binaryPath := defaultRsh
+	if o.BinaryPath != "" {
+		binaryPath = o.BinaryPath
+	}
+
+	var arguments []string
+	if o.Port > 0 {
+		arguments = append(arguments, "-p", strconv.Itoa(o.Port))
+	}
+	if o.IdentityFile != "" {
+		arguments = append(arguments, "-i", o.IdentityFile)
+	}
+	for _, option := range o.Options {
+		arguments = append(arguments, "-o", option)
+	}
+
+	return binaryPath, arguments
+}
+
+// Apply returns rsync options with Rsh built from ssh options
+func (o SSHOptions) Apply(options RsyncOptions) RsyncOptions {
+	options.Rsh = o.Rsh()
+	return options
+}
+
+// With returns copy of ssh options with additional `-o` options
+func (o SSHOptions) With(options ...string) SSHOptions {
+	o.Options = append(append([]string(nil), o.Options...), options...)
+	return o
+}
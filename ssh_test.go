@@ -0,0 +1,24 @@
+package grsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSHOptionsRsh(t *testing.T) {
+	assert.Equal(t, "ssh", SSHOptions{}.Rsh())
+
+	options := SSHOptions{
+		BinaryPath:   "/usr/bin/ssh",
+		Port:         2222,
+		IdentityFile: "/keys/my key",
+		Options:      []string{"BatchMode=yes"},
+	}
+	assert.Equal(t, "/usr/bin/ssh -p 2222 -i '/keys/my key' -o BatchMode=yes", options.Rsh())
+	assert.Equal(t, options.Rsh(), options.Apply(RsyncOptions{}).Rsh)
+
+	extended := options.With("ControlMaster=no")
+	assert.Equal(t, []string{"BatchMode=yes", "ControlMaster=no"}, extended.Options)
+	assert.Equal(t, []string{"BatchMode=yes"}, options.Options)
+}
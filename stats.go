@@ -0,0 +1,97 @@
+package grsync
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+// Stats contains information from rsync --stats output
+type Stats struct {
+	Files            int   `json:"files"`
+	FilesCreated     int   `json:"files created"`
+	FilesDeleted     int   `json:"files deleted"`
+	FilesTransferred int   `json:"files transferred"`
+	TotalSize        int64 `json:"total size"`
+	TransferredSize  int64 `json:"transferred size"`
+	BytesSent        int64 `json:"bytes sent"`
+	BytesReceived    int64 `json:"bytes received"`
+	// ProgressBytes is file data counted from progress output; it's known even when rsync
+	// is stopped before it prints stats
+	ProgressBytes int64 `json:"progress bytes"`
+}
+
+// Stats returns transfer statistics; rsync should be started with Stats option
+func (t *Task) Stats() Stats {
+	stats := parseStats(t.log.Stdout)
+	stats.ProgressBytes = t.progressBytes
+	return stats
+}
+
+func parseStats(output string) Stats {
+	const keyValueSeparator = ": "
+
+	var stats Stats
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		index := strings.Index(line, keyValueSeparator)
+		if index < 0 {
+			continue
+		}
+
+		key := line[:index]
+		value := strings.Fields(line[index+len(keyValueSeparator):])
+		if len(value) == 0 {
+			continue
+		}
+
+		switch key {
+		case "Number of files":
+			stats.Files = int(parseSize(value[0]))
+		case "Number of created files":
+			stats.FilesCreated = int(parseSize(value[0]))
+		case "Number of deleted files":
+			stats.FilesDeleted = int(parseSize(value[0]))
+		// rsync before 3.1 doesn't count regular files separately
+		case "Number of regular files transferred", "Number of files transferred":
+			stats.FilesTransferred = int(parseSize(value[0]))
+		case "Total file size":
+			stats.TotalSize = parseSize(value[0])
+		case "Total transferred file size":
+			stats.TransferredSize = parseSize(value[0])
+		case "Total bytes sent":
+			stats.BytesSent = parseSize(value[0])
+		case "Total bytes received":
+			stats.BytesReceived = parseSize(value[0])
+		}
+	}
+
+	return stats
+}
+
+// parseSize converts numbers printed by rsync, e.g. `1,234` or `1.05M`, into bytes
+func parseSize(size string) int64 {
+	const unitBase = 1000
+	const units = "KMGTP"
+
+	size = strings.ReplaceAll(size, ",", "")
+	if size == "" {
+		return 0
+	}
+
+	multiplier := float64(1)
+	if index := strings.IndexByte(units, size[len(size)-1]); index >= 0 {
+		for i := 0; i <= index; i++ {
+			multiplier *= unitBase
+		}
+		size = size[:len(size)-1]
+	}
+
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil {
+		return 0
+	}
+
+	return int64(value * multiplier)
+}
@@ -0,0 +1,49 @@
+package grsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStats(t *testing.T) {
+	const output = `sending incremental file list
+file1
+
+Number of files: 3 (reg: 2, dir: 1)
+Number of created files: 2 (reg: 2)
+Number of deleted files: 1 (reg: 1)
+Number of regular files transferred: 2
+Total file size: 1.05M bytes
+Total transferred file size: 1,234 bytes
+Literal data: 1.05M bytes
+Total bytes sent: 1.05M
+Total bytes received: 57
+
+sent 1.05M bytes  received 57 bytes  2.10M bytes/sec
+`
+	assert.Equal(t, Stats{
+		Files:            3,
+		FilesCreated:     2,
+		FilesDeleted:     1,
+		FilesTransferred: 2,
+		TotalSize:        1050000,
+		TransferredSize:  1234,
+		BytesSent:        1050000,
+		BytesReceived:    57,
+	}, parseStats(output))
+}
+
+func TestParseStatsOldFormat(t *testing.T) {
+	stats := parseStats("Number of files transferred: 7\n")
+	assert.Equal(t, 7, stats.FilesTransferred)
+}
+
+func TestParseSize(t *testing.T) {
+	assert.Equal(t, int64(0), parseSize(""))
+	assert.Equal(t, int64(999), parseSize("999"))
+	assert.Equal(t, int64(1234567), parseSize("1,234,567"))
+	assert.Equal(t, int64(32770), parseSize("32.77K"))
+	assert.Equal(t, int64(2000000000), parseSize("2.00G"))
+	assert.Equal(t, int64(0), parseSize("bytes"))
+}
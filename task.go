@@ -18,10 +18,20 @@ var (
 
 // Task is high-level API under rsync
 type Task struct {
-	rsync *Rsync
-
-	state *State
-	log   *Log
+	rsync    *Rsync
+	newRsync func(RsyncOptions) *Rsync
+	options  RsyncOptions
+
+	state         *State
+	log           *Log
+	files         *filePool
+	byteCap       int64
+	progressBytes int64
+	dirsSynced    bool
+	succeeded     bool
+	fastPath      FastPathStats
+	middleware    []Middleware
+	listeners     []func(Event)
 
 	stdout io.Writer
 	stderr io.Writer
@@ -63,8 +73,26 @@ func (t *Task) Log() Log {
 	}
 }
 
-// Run starts rsync process with options
-func (t *Task) Run() (err error) {
+// Options returns rsync options of the task
+func (t *Task) Options() RsyncOptions {
+	return t.options
+}
+
+// Run starts rsync process with options through the middleware chain
+func (t *Task) Run() error {
+	if len(t.middleware) == 0 {
+		return t.run()
+	}
+	return chain(RunnerFunc((*Task).run), t.middleware).Run(t)
+}
+
+func (t *Task) run() (err error) {
+	defer func() {
+		stats := t.Stats()
+		guarantees := t.Guarantees()
+		t.emit(Event{Type: EventResult, Stats: &stats, Guarantees: &guarantees, Err: err})
+	}()
+
 	var stderr, stdout io.ReadCloser
 	if stderr, err = t.rsync.StderrPipe(); err != nil {
 		return err
@@ -80,6 +108,17 @@ func (t *Task) Run() (err error) {
 		_ = stdout.Close()
 	}()
 
+	// bytes are counted without the cap too, rsync stopped by a signal doesn't print stats
+	counter := &byteCounter{limit: t.byteCap, onLimit: t.stopGracefully}
+	stdout = readCloser{Reader: io.TeeReader(stdout, counter), Closer: stdout}
+
+	if err = t.rsync.start(); err != nil {
+		return err
+	}
+	if t.files != nil {
+		t.files.start()
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
@@ -92,39 +131,81 @@ func (t *Task) Run() (err error) {
 		wg.Done()
 	}()
 
-	err = t.rsync.Run()
+	// pipes must be read to the end before Wait closes them
 	wg.Wait()
+	t.progressBytes = counter.total()
+	err = t.rsync.cmd.Wait()
 
-	return err
+	var filesErr error
+	if t.files != nil {
+		filesErr = t.files.wait()
+	}
+	if err != nil {
+		if counter.reached {
+			return ErrByteCapReached
+		}
+		return classifyError(err, t.log.Stderr)
+	}
+	if err = t.syncDirs(); err != nil {
+		return err
+	}
+	t.succeeded = true
+	return filesErr
 }
 
 // NewTask returns new rsync task
 func NewTask(source []string, destination string, rsyncOptions RsyncOptions) *Task {
-	// Force set required options
-	rsyncOptions.HumanReadable = true
-	rsyncOptions.Partial = true
-	rsyncOptions.Progress = true
-	rsyncOptions.Archive = true
-
-	return &Task{
-		rsync:  NewRsync(source, destination, rsyncOptions),
-		state:  &State{},
-		log:    &Log{},
-		stdout: io.Discard,
-		stderr: io.Discard,
-	}
+	rsyncOptions = forceOptions(rsyncOptions)
+	return newTask(rsyncOptions, func(rsyncOptions RsyncOptions) *Rsync {
+		return NewRsync(source, destination, rsyncOptions)
+	})
 }
 
 func NewTaskWithoutForceOptions(source []string, destination string, rsyncOptions RsyncOptions) *Task {
+	return newTask(rsyncOptions, func(rsyncOptions RsyncOptions) *Rsync {
+		return NewRsync(source, destination, rsyncOptions)
+	})
+}
+
+// newTask returns task around rsync built by newRsync, it's called again on restart
+func newTask(rsyncOptions RsyncOptions, newRsync func(RsyncOptions) *Rsync) *Task {
 	return &Task{
-		rsync:  NewRsync(source, destination, rsyncOptions),
-		state:  &State{},
-		log:    &Log{},
-		stdout: io.Discard,
-		stderr: io.Discard,
+		rsync:    newRsync(rsyncOptions),
+		newRsync: newRsync,
+		options:  rsyncOptions,
+		state:    &State{},
+		log:      &Log{},
+		stdout:   io.Discard,
+		stderr:   io.Discard,
 	}
 }
 
+// restart prepares the task to run rsync once more
+func (t *Task) restart() {
+	t.rsync = t.newRsync(t.options)
+	t.state = &State{}
+	t.log = &Log{}
+	t.dirsSynced = false
+	t.succeeded = false
+	t.progressBytes = 0
+}
+
+// setOptions rebuilds rsync command of the task with other options
+func (t *Task) setOptions(rsyncOptions RsyncOptions) {
+	t.options = rsyncOptions
+	t.rsync = t.newRsync(rsyncOptions)
+}
+
+// forceOptions sets options required by Task to track progress
+func forceOptions(rsyncOptions RsyncOptions) RsyncOptions {
+	rsyncOptions.HumanReadable = true
+	rsyncOptions.Partial = true
+	rsyncOptions.Progress = true
+	rsyncOptions.Archive = true
+
+	return rsyncOptions
+}
+
 func processStdout(task *Task, stdout io.Reader) {
 	const maxPercents = float64(100)
 	const minDivider = 1
@@ -136,6 +217,7 @@ func processStdout(task *Task, stdout io.Reader) {
 		logStr := scanner.Text()
 
 		_, _ = task.stdout.Write(scanner.Bytes())
+		previous := *task.state
 		if progressMatcher.Match(logStr) {
 			task.state.Remain, task.state.Total = getTaskProgress(progressMatcher.Extract(logStr))
 
@@ -147,10 +229,21 @@ func processStdout(task *Task, stdout io.Reader) {
 			task.state.Speed = getTaskSpeed(speedMatcher.ExtractAllStringSubmatch(logStr, 2))
 		}
 
-		if fileMatcher.MatchString(logStr) {
+		if event, ok := parseItemized(logStr, task.rsync.Destination); ok {
+			task.state.CopiedObject = event.Name
+			task.emit(Event{Type: EventFile, File: &event})
+		} else if fileMatcher.MatchString(logStr) {
 			task.state.CopiedObject = fileMatcher.FindString(logStr)
 		}
 
+		if *task.state != previous {
+			task.emit(Event{Type: EventState, State: task.State()})
+		}
+
+		if task.files != nil {
+			task.files.line(logStr)
+		}
+
 		task.log.Stdout += logStr + "\n"
 	}
 }
@@ -1,6 +1,8 @@
 package grsync
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -8,7 +10,7 @@ import (
 
 func TestTask(t *testing.T) {
 	t.Run("create new empty Task", func(t *testing.T) {
-		createdTask := NewTask("a", "b", RsyncOptions{})
+		createdTask := NewTask([]string{"a"}, "b", RsyncOptions{})
 
 		assert.Empty(t, createdTask.Log(), "Task log should return empty string")
 		assert.Empty(t, createdTask.State(), "Task should inited with empty state")
@@ -39,3 +41,15 @@ func TestTaskSpeedParse(t *testing.T) {
 	speed := getTaskSpeed(speedMatcher.ExtractAllStringSubmatch(taskInfoString, 2))
 	assert.Equal(t, "999.99kB/s", speed)
 }
+
+// writeFakeRsync creates a shell script standing in for the rsync binary
+func writeFakeRsync(t *testing.T, script string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "rsync")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
@@ -0,0 +1,173 @@
+package grsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrOutsideRoot is returned when an expanded path leaves the configured root
+var ErrOutsideRoot = errors.New("rsync: expanded path is outside the root")
+
+// templateMatcher finds variables such as `{{host}}` or `{{date:2006-01-02}}`
+var templateMatcher = regexp.MustCompile(`{{\s*([a-z]+)(?::([^}]*))?\s*}}`)
+
+// TemplateVars are values of template variables:
+// {{host}}, {{job}}, {{date:LAYOUT}}, {{env:NAME}} and {{previous}}
+type TemplateVars struct {
+	// Job is a job name
+	Job string
+	// Host is a host name; by default os.Hostname
+	Host string
+	// Time is used by date variables; by default the current time
+	Time time.Time
+	// Previous is a destination of the previous successful run, it's empty for the first run
+	Previous string
+}
+
+// ExpandTemplate replaces variables in the template; an unknown variable or unset
+// environment variable is an error
+func ExpandTemplate(template string, vars TemplateVars) (string, error) {
+	if !strings.Contains(template, "{{") {
+		return template, nil
+	}
+
+	if vars.Time.IsZero() {
+		vars.Time = time.Now()
+	}
+
+	var err error
+	expanded := templateMatcher.ReplaceAllStringFunc(template, func(variable string) string {
+		match := templateMatcher.FindStringSubmatch(variable)
+		name, argument := match[1], strings.TrimSpace(match[2])
+
+		switch name {
+		case "host":
+			if vars.Host == "" {
+				vars.Host, err = os.Hostname()
+			}
+			return vars.Host
+		case "job":
+			return vars.Job
+		case "date":
+			if argument == "" {
+				argument = "2006-01-02"
+			}
+			return vars.Time.Format(argument)
+		case "env":
+			value, ok := os.LookupEnv(argument)
+			if !ok {
+				err = fmt.Errorf("rsync: environment variable %q of template %q is not set", argument, template)
+			}
+			return value
+		case "previous":
+			return vars.Previous
+		}
+
+		err = fmt.Errorf("rsync: unknown variable %q in template %q", variable, template)
+		return variable
+	})
+
+	if err == nil && strings.Contains(expanded, "{{") {
+		err = fmt.Errorf("rsync: malformed template %q", template)
+	}
+	return expanded, err
+}
+
+// Expand returns the job with templates expanded in Source, Destination, LinkDest, BackupDir and PartialDir.
+// With non-empty root the destination side paths must stay under root; relative LinkDest,
+// BackupDir and PartialDir are resolved against the destination like rsync does.
+func (j Job) Expand(vars TemplateVars, root string) (Job, error) {
+	if vars.Job == "" {
+		vars.Job = j.Name
+	}
+	if vars.Time.IsZero() {
+		// all paths of the job get the same date
+		vars.Time = time.Now()
+	}
+
+	var err error
+	expand := func(template string) string {
+		if err != nil {
+			return template
+		}
+		var expanded string
+		expanded, err = ExpandTemplate(template, vars)
+		return expanded
+	}
+
+	j.Source = append([]string{}, j.Source...)
+	for i, source := range j.Source {
+		j.Source[i] = expand(source)
+	}
+	j.Destination = expand(j.Destination)
+	j.Options.LinkDest = expand(j.Options.LinkDest)
+	j.Options.BackupDir = expand(j.Options.BackupDir)
+	j.Options.PartialDir = expand(j.Options.PartialDir)
+	if err != nil || root == "" {
+		return j, err
+	}
+
+	destination := localPart(j.Destination)
+	if err = checkUnderRoot(root, destination); err != nil {
+		return j, err
+	}
+	for _, path := range []string{j.Options.LinkDest, j.Options.BackupDir, j.Options.PartialDir} {
+		if path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(destination, path)
+		}
+		if err = checkUnderRoot(root, path); err != nil {
+			return j, err
+		}
+	}
+	return j, nil
+}
+
+// templatesUseName reports whether paths of the job have {{job}} or {{previous}}, which is kept by name
+func (j Job) templatesUseName() bool {
+	paths := append([]string{j.Destination, j.Options.LinkDest, j.Options.BackupDir, j.Options.PartialDir}, j.Source...)
+	for _, path := range paths {
+		for _, match := range templateMatcher.FindAllStringSubmatch(path, -1) {
+			if match[1] == "job" || match[1] == "previous" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// localPart returns path part of a remote path, e.g. `/data` of `host:/data`
+func localPart(path string) string {
+	if strings.HasPrefix(path, "rsync://") {
+		path = strings.TrimPrefix(path, "rsync://")
+		if index := strings.IndexByte(path, '/'); index >= 0 {
+			return path[index:]
+		}
+		return "/"
+	}
+	if isRemotePath(path) {
+		return path[strings.IndexByte(path, ':')+1:]
+	}
+	return path
+}
+
+func checkUnderRoot(root, path string) error {
+	if filepath.IsAbs(root) && !filepath.IsAbs(path) {
+		if absolute, err := filepath.Abs(path); err == nil {
+			path = absolute
+		}
+	}
+
+	relative, err := filepath.Rel(filepath.Clean(root), filepath.Clean(path))
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s", ErrOutsideRoot, path)
+	}
+	return nil
+}
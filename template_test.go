@@ -0,0 +1,134 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	t.Setenv("GRSYNC_TEAM", "media")
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	vars := TemplateVars{
+		Job:      "photos",
+		Time:     time.Date(2022, 3, 1, 15, 4, 5, 0, time.UTC),
+		Previous: "/backups/2022-02-28",
+	}
+	for template, expected := range map[string]string{
+		"/backups/plain":                            "/backups/plain",
+		"/backups/{{host}}/{{job}}":                 "/backups/" + hostname + "/photos",
+		"/backups/{{date:2006-01-02}}/{{ job }}":    "/backups/2022-03-01/photos",
+		"/backups/{{date}}_{{date:150405}}":         "/backups/2022-03-01_150405",
+		"/backups/{{env:GRSYNC_TEAM}}/{{previous}}": "/backups/media//backups/2022-02-28",
+	} {
+		expanded, err := ExpandTemplate(template, vars)
+		assert.NoError(t, err, template)
+		assert.Equal(t, expected, expanded)
+	}
+
+	for _, template := range []string{"/{{unknown}}", "/{{env:GRSYNC_UNSET}}", "/{{job"} {
+		_, err := ExpandTemplate(template, vars)
+		assert.Error(t, err, template)
+	}
+}
+
+func TestJobExpand(t *testing.T) {
+	job := Job{
+		Name:        "photos",
+		Source:      []string{"/data/{{job}}/"},
+		Destination: "/backups/{{env:GRSYNC_SUBDIR}}/{{date:2006}}",
+		Options: RsyncOptions{
+			LinkDest:   "{{previous}}",
+			BackupDir:  "../changed/{{date:2006}}",
+			PartialDir: ".partial",
+		},
+	}
+	vars := TemplateVars{Time: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), Previous: "/backups/photos/2021"}
+
+	t.Setenv("GRSYNC_SUBDIR", "photos")
+	expanded, err := job.Expand(vars, "/backups")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/data/photos/"}, expanded.Source)
+	assert.Equal(t, "/backups/photos/2022", expanded.Destination)
+	assert.Equal(t, "/backups/photos/2021", expanded.Options.LinkDest)
+	assert.Equal(t, "../changed/2022", expanded.Options.BackupDir)
+	assert.Equal(t, []string{"/data/{{job}}/"}, job.Source)
+
+	_, err = job.Expand(vars, "/backups/photos/2022")
+	assert.True(t, errors.Is(err, ErrOutsideRoot))
+
+	t.Setenv("GRSYNC_SUBDIR", "../etc")
+	_, err = job.Expand(vars, "/backups")
+	assert.True(t, errors.Is(err, ErrOutsideRoot))
+
+	job.Destination = "host:/backups/{{env:GRSYNC_SUBDIR}}"
+	job.Options = RsyncOptions{}
+	_, err = job.Expand(vars, "/backups")
+	assert.True(t, errors.Is(err, ErrOutsideRoot))
+}
+
+func TestSchedulerTemplates(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	var executed []Job
+	scheduler := NewScheduler(SchedulerOptions{
+		Store: store,
+		Root:  "/backups",
+		Executor: func(ctx context.Context, job Job) (Stats, error) {
+			executed = append(executed, job)
+			return Stats{}, nil
+		},
+	})
+	defer func() {
+		_ = scheduler.Close()
+	}()
+
+	job := Job{
+		Name:        "photos",
+		Source:      []string{"/data/"},
+		Destination: "/backups/{{job}}/{{env:GRSYNC_RUN}}",
+		Options:     RsyncOptions{LinkDest: "{{previous}}"},
+	}
+	for _, run := range []string{"1", "2"} {
+		t.Setenv("GRSYNC_RUN", run)
+		_, err = scheduler.Submit(job).Wait()
+		require.NoError(t, err)
+	}
+
+	require.Len(t, executed, 2)
+	assert.Equal(t, "/backups/photos/1", executed[0].Destination)
+	assert.Empty(t, executed[0].Options.LinkDest)
+	assert.Equal(t, "/backups/photos/2", executed[1].Destination)
+	assert.Equal(t, "/backups/photos/1", executed[1].Options.LinkDest)
+
+	records, err := store.Load()
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	t.Run("remote", func(t *testing.T) {
+		executed = nil
+		job := job
+		job.Name = "remote"
+		job.Destination = "backup-host:/backups/{{job}}/{{env:GRSYNC_RUN}}"
+		for _, run := range []string{"1", "2"} {
+			t.Setenv("GRSYNC_RUN", run)
+			_, err = scheduler.Submit(job).Wait()
+			require.NoError(t, err)
+		}
+
+		require.Len(t, executed, 2)
+		assert.Equal(t, "backup-host:/backups/remote/2", executed[1].Destination)
+		assert.Equal(t, "/backups/remote/1", executed[1].Options.LinkDest)
+	})
+
+	t.Setenv("GRSYNC_RUN", "../../etc")
+	_, err = scheduler.Submit(job).Wait()
+	assert.True(t, errors.Is(err, ErrOutsideRoot))
+}
@@ -0,0 +1,46 @@
+package grsync
+
+import (
+	"fmt"
+)
+
+// NotInSyncError is returned by Verify when destination differs from source
+type NotInSyncError struct {
+	Changes []FileEvent
+}
+
+func (e *NotInSyncError) Error() string {
+	return fmt.Sprintf("rsync: %d files differ, first: %s", len(e.Changes), e.Changes[0].Name)
+}
+
+// Verify compares source and destination by checksum with a dry run
+// and returns NotInSyncError listing changes the real run would make
+func Verify(source []string, destination string, rsyncOptions RsyncOptions) error {
+	rsyncOptions.Checksum = true
+
+	changes, err := dryRun(source, destination, rsyncOptions)
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		return &NotInSyncError{Changes: changes}
+	}
+	return nil
+}
+
+// dryRun returns changes the real run would make
+func dryRun(source []string, destination string, rsyncOptions RsyncOptions) ([]FileEvent, error) {
+	rsyncOptions = forceOptions(rsyncOptions)
+	rsyncOptions.DryRun = true
+	rsyncOptions.ItemizeChanges = true
+
+	var changes []FileEvent
+	task := NewTaskWithoutForceOptions(source, destination, rsyncOptions)
+	task.OnEvent(func(event Event) {
+		if event.Type == EventFile {
+			changes = append(changes, *event.File)
+		}
+	})
+
+	return changes, task.Run()
+}
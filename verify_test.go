@@ -0,0 +1,30 @@
+package grsync
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	t.Run("in sync", func(t *testing.T) {
+		binary := writeFakeRsync(t, `echo "sending incremental file list"`)
+		assert.NoError(t, Verify([]string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary}))
+	})
+
+	t.Run("differs", func(t *testing.T) {
+		binary := writeFakeRsync(t, `for arg; do echo "$arg"; done > "$(dirname "$0")/calls"
+`+itemizingRsync)
+		err := Verify([]string{"src/"}, t.TempDir(), RsyncOptions{RsyncBinaryPath: binary})
+
+		var notInSync *NotInSyncError
+		assert.True(t, errors.As(err, &notInSync))
+		assert.Len(t, notInSync.Changes, 7)
+
+		args := readCalls(t, binary)
+		for _, arg := range []string{"--dry-run\n", "--checksum\n", "--itemize-changes\n"} {
+			assert.Contains(t, args[0], arg)
+		}
+	})
+}
@@ -0,0 +1,314 @@
+package grsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// ErrWorkflowFailed is returned when a step without ContinueOnError fails
+var ErrWorkflowFailed = errors.New("rsync: workflow failed")
+
+// StepStatus is a status of a workflow step
+type StepStatus string
+
+const (
+	StepPending   StepStatus = "pending"
+	StepSucceeded StepStatus = "succeeded"
+	StepFailed    StepStatus = "failed"
+	// StepSkipped step isn't run because its dependency failed
+	StepSkipped StepStatus = "skipped"
+)
+
+// Step is a unit of Workflow
+type Step struct {
+	Name string
+	// DependsOn are names of steps which must be done first
+	DependsOn []string
+	// ContinueOnError runs dependent steps even if the step fails
+	ContinueOnError bool
+	// Run does the work of the step
+	Run func(ctx context.Context) error
+}
+
+// TaskStep returns step which syncs source to destination
+func TaskStep(name string, source []string, destination string, rsyncOptions RsyncOptions, dependsOn ...string) Step {
+	return Step{
+		Name:      name,
+		DependsOn: dependsOn,
+		Run: func(ctx context.Context) error {
+			rsyncOptions.RsyncContext = ctx
+			return NewTask(source, destination, rsyncOptions).Run()
+		},
+	}
+}
+
+// VerifyStep returns step which fails if destination differs from source
+func VerifyStep(name string, source []string, destination string, rsyncOptions RsyncOptions, dependsOn ...string) Step {
+	return Step{
+		Name:      name,
+		DependsOn: dependsOn,
+		Run: func(ctx context.Context) error {
+			rsyncOptions.RsyncContext = ctx
+			return Verify(source, destination, rsyncOptions)
+		},
+	}
+}
+
+// CommandStep returns step which runs command, e.g. a remote command through ssh
+func CommandStep(name string, command []string, dependsOn ...string) Step {
+	return Step{
+		Name:      name,
+		DependsOn: dependsOn,
+		Run: func(ctx context.Context) error {
+			if len(command) == 0 {
+				return errors.New("rsync: empty command")
+			}
+			output, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, output)
+			}
+			return nil
+		},
+	}
+}
+
+// StepReport contains result of a workflow step
+type StepReport struct {
+	Name      string        `json:"name"`
+	Status    StepStatus    `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	StartedAt time.Time     `json:"started at"`
+	Duration  time.Duration `json:"duration"`
+	Err       error         `json:"-"`
+}
+
+// WorkflowReport contains results of all workflow steps in definition order
+type WorkflowReport struct {
+	Steps []StepReport `json:"steps"`
+}
+
+// Step returns report of the step with the given name
+func (r WorkflowReport) Step(name string) (StepReport, bool) {
+	for _, step := range r.Steps {
+		if step.Name == name {
+			return step, true
+		}
+	}
+	return StepReport{}, false
+}
+
+// Workflow runs dependent steps with bounded concurrency
+type Workflow struct {
+	Steps []Step
+	// Concurrency limits steps running at once; by default 1
+	Concurrency int
+}
+
+// Run runs steps after their dependencies; steps depending on a failed step are skipped
+func (w *Workflow) Run(ctx context.Context) (WorkflowReport, error) {
+	if err := w.validate(); err != nil {
+		return WorkflowReport{}, err
+	}
+
+	concurrency := w.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	report := WorkflowReport{Steps: make([]StepReport, len(w.Steps))}
+	for i, step := range w.Steps {
+		report.Steps[i] = StepReport{Name: step.Name, Status: StepPending}
+	}
+
+	type result struct {
+		index int
+		err   error
+	}
+	results := make(chan result)
+	running, done := 0, 0
+	started := make([]bool, len(w.Steps))
+
+	for done < len(w.Steps) {
+		for i, step := range w.Steps {
+			if started[i] || running >= concurrency {
+				continue
+			}
+
+			ready, skip := w.dependencies(step, report)
+			if skip {
+				started[i] = true
+				report.Steps[i].Status = StepSkipped
+				done++
+				continue
+			}
+			if !ready || ctx.Err() != nil {
+				continue
+			}
+
+			started[i] = true
+			running++
+			report.Steps[i].StartedAt = time.Now()
+			go func(i int, step Step) {
+				results <- result{index: i, err: step.Run(ctx)}
+			}(i, step)
+		}
+
+		if running == 0 {
+			// nothing can start anymore, e.g. the context is done
+			break
+		}
+
+		res := <-results
+		running--
+		done++
+		stepReport := &report.Steps[res.index]
+		stepReport.Duration = time.Since(stepReport.StartedAt)
+		stepReport.Status = StepSucceeded
+		if res.err != nil {
+			stepReport.Status = StepFailed
+			stepReport.Err = res.err
+			stepReport.Error = res.err.Error()
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	for i, step := range w.Steps {
+		if report.Steps[i].Status == StepFailed && !step.ContinueOnError {
+			return report, ErrWorkflowFailed
+		}
+	}
+	return report, nil
+}
+
+// dependencies reports whether the step is ready to run or must be skipped
+func (w *Workflow) dependencies(step Step, report WorkflowReport) (bool, bool) {
+	ready := true
+	for _, name := range step.DependsOn {
+		index := w.index(name)
+		switch report.Steps[index].Status {
+		case StepPending:
+			ready = false
+		case StepSkipped:
+			return false, true
+		case StepFailed:
+			if !w.Steps[index].ContinueOnError {
+				return false, true
+			}
+		}
+	}
+	return ready, false
+}
+
+// validate checks that names are unique, dependencies exist and there are no cycles
+func (w *Workflow) validate() error {
+	names := map[string]bool{}
+	for _, step := range w.Steps {
+		if step.Name == "" || names[step.Name] {
+			return fmt.Errorf("rsync: workflow step name %q is empty or duplicated", step.Name)
+		}
+		if step.Run == nil {
+			return fmt.Errorf("rsync: workflow step %q has nothing to run", step.Name)
+		}
+		names[step.Name] = true
+	}
+
+	for _, step := range w.Steps {
+		for _, name := range step.DependsOn {
+			if !names[name] {
+				return fmt.Errorf("rsync: workflow step %q depends on unknown step %q", step.Name, name)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(w.Steps))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch marks[i] {
+		case visiting:
+			return fmt.Errorf("rsync: workflow step %q is a part of dependency cycle", w.Steps[i].Name)
+		case visited:
+			return nil
+		}
+		marks[i] = visiting
+		for _, name := range w.Steps[i].DependsOn {
+			if err := visit(w.index(name)); err != nil {
+				return err
+			}
+		}
+		marks[i] = visited
+		return nil
+	}
+	for i := range w.Steps {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *Workflow) index(name string) int {
+	for i, step := range w.Steps {
+		if step.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// workflowConfig is a job config of the workflow
+type workflowConfig struct {
+	Concurrency int `json:"concurrency"`
+	Steps       []struct {
+		Name            string       `json:"name"`
+		Type            string       `json:"type"`
+		DependsOn       []string     `json:"depends_on"`
+		ContinueOnError bool         `json:"continue_on_error"`
+		Source          []string     `json:"source"`
+		Destination     string       `json:"destination"`
+		Options         RsyncOptions `json:"options"`
+		Command         []string     `json:"command"`
+	} `json:"steps"`
+}
+
+// LoadWorkflow reads workflow from JSON job config. Step type is `sync`, `verify` or `command`,
+// options of sync and verify steps are RsyncOptions.
+func LoadWorkflow(r io.Reader) (*Workflow, error) {
+	var config workflowConfig
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	workflow := &Workflow{Concurrency: config.Concurrency}
+	for _, stepConfig := range config.Steps {
+		var step Step
+		switch stepConfig.Type {
+		case "sync", "":
+			step = TaskStep(stepConfig.Name, stepConfig.Source, stepConfig.Destination, stepConfig.Options, stepConfig.DependsOn...)
+		case "verify":
+			step = VerifyStep(stepConfig.Name, stepConfig.Source, stepConfig.Destination, stepConfig.Options, stepConfig.DependsOn...)
+		case "command":
+			step = CommandStep(stepConfig.Name, stepConfig.Command, stepConfig.DependsOn...)
+		default:
+			return nil, fmt.Errorf("rsync: unknown workflow step type %q", stepConfig.Type)
+		}
+		step.ContinueOnError = stepConfig.ContinueOnError
+		workflow.Steps = append(workflow.Steps, step)
+	}
+
+	return workflow, workflow.validate()
+}
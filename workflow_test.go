@@ -0,0 +1,134 @@
+package grsync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkflow(t *testing.T) {
+	t.Run("order and concurrency", func(t *testing.T) {
+		var mu sync.Mutex
+		var order []string
+		running, maxRunning := 0, 0
+		step := func(name string, dependsOn ...string) Step {
+			return Step{Name: name, DependsOn: dependsOn, Run: func(ctx context.Context) error {
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				order = append(order, name)
+				mu.Unlock()
+				return nil
+			}}
+		}
+
+		workflow := Workflow{Concurrency: 2, Steps: []Step{
+			step("notify", "verify"),
+			step("config"),
+			step("host1", "config"),
+			step("host2", "config"),
+			step("host3", "config"),
+			step("verify", "host1", "host2", "host3"),
+		}}
+		report, err := workflow.Run(context.Background())
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, maxRunning)
+		assert.Equal(t, "config", order[0])
+		assert.Equal(t, []string{"verify", "notify"}, order[4:])
+		for _, step := range report.Steps {
+			assert.Equal(t, StepSucceeded, step.Status, step.Name)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		failed := errors.New("failed")
+		ok := func(ctx context.Context) error { return nil }
+		workflow := Workflow{Steps: []Step{
+			{Name: "hook", ContinueOnError: true, Run: func(ctx context.Context) error { return failed }},
+			{Name: "sync", DependsOn: []string{"hook"}, Run: func(ctx context.Context) error { return failed }},
+			{Name: "verify", DependsOn: []string{"sync"}, Run: ok},
+			{Name: "notify", DependsOn: []string{"verify"}, Run: ok},
+			{Name: "other", Run: ok},
+		}}
+		report, err := workflow.Run(context.Background())
+		assert.ErrorIs(t, err, ErrWorkflowFailed)
+
+		expected := map[string]StepStatus{
+			"hook":   StepFailed,
+			"sync":   StepFailed,
+			"verify": StepSkipped,
+			"notify": StepSkipped,
+			"other":  StepSucceeded,
+		}
+		for name, status := range expected {
+			step, ok := report.Step(name)
+			assert.True(t, ok)
+			assert.Equal(t, status, step.Status, name)
+		}
+		step, _ := report.Step("sync")
+		assert.Equal(t, "failed", step.Error)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		ok := func(ctx context.Context) error { return nil }
+		for name, steps := range map[string][]Step{
+			"duplicate": {{Name: "a", Run: ok}, {Name: "a", Run: ok}},
+			"unknown":   {{Name: "a", DependsOn: []string{"b"}, Run: ok}},
+			"cycle":     {{Name: "a", DependsOn: []string{"b"}, Run: ok}, {Name: "b", DependsOn: []string{"a"}, Run: ok}},
+		} {
+			workflow := Workflow{Steps: steps}
+			_, err := workflow.Run(context.Background())
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestLoadWorkflow(t *testing.T) {
+	binary := writeFakeRsync(t, `for arg; do echo "$arg"; done >> "$(dirname "$0")/calls"
+echo "---" >> "$(dirname "$0")/calls"
+echo "sending incremental file list"`)
+	config := `{
+		"concurrency": 2,
+		"steps": [
+			{"name": "data", "source": ["src/"], "destination": "` + t.TempDir() + `",
+				"options": {"RsyncBinaryPath": "` + binary + `", "Delete": true}},
+			{"name": "verify", "type": "verify", "depends_on": ["data"], "source": ["src/"], "destination": "` + t.TempDir() + `",
+				"options": {"RsyncBinaryPath": "` + binary + `"}},
+			{"name": "remote", "type": "command", "depends_on": ["verify"], "command": ["true"]}
+		]
+	}`
+
+	workflow, err := LoadWorkflow(strings.NewReader(config))
+	require.NoError(t, err)
+	assert.Equal(t, 2, workflow.Concurrency)
+	require.Len(t, workflow.Steps, 3)
+
+	report, err := workflow.Run(context.Background())
+	require.NoError(t, err)
+	for _, step := range report.Steps {
+		assert.Equal(t, StepSucceeded, step.Status, step.Name)
+	}
+
+	calls := readCalls(t, binary)
+	require.Len(t, calls, 2)
+	assert.Contains(t, calls[0], "--delete\n")
+	assert.Contains(t, calls[1], "--dry-run\n")
+
+	_, err = LoadWorkflow(strings.NewReader(`{"steps": [{"name": "a", "type": "unknown"}]}`))
+	assert.Error(t, err)
+}